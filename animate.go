@@ -0,0 +1,208 @@
+package pixelsort
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+	"sort"
+)
+
+// Renders the sort step spans at a time. The first frame is the original and
+// the last is the fully sorted image. The spans are sorted once, and each
+// frame writes step more of them than the one before.
+func SortImageFrames(ctx context.Context, img image.Image, opts Options, step int) ([]image.Image, error) {
+	if step < 1 {
+		return nil, errors.New("Animation step must be at least 1.")
+	}
+
+	var sorted sortedSpans
+	opts.sorted = &sorted
+	final, err := SortImageContext(ctx, img, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := []image.Image{img}
+	for n := step; n < sorted.count; n += step {
+		frame, err := sorted.apply(n)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	frames = append(frames, final)
+
+	return frames, nil
+}
+
+// Picks up to 256 colors for an image by averaging the colors that fall in
+// the most common cells of a 32x32x32 grid over RGB. When the image has no
+// more than 256 colors they are used exactly.
+func quantizePalette(img image.Image) (color.Palette, bool) {
+	b := img.Bounds()
+	exact := make(map[color.RGBA]bool)
+	type cell struct {
+		r, g, b, n int
+	}
+	cells := make(map[int]*cell)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			c.A = 255
+			if len(exact) <= 256 {
+				exact[c] = true
+			}
+			key := int(c.R>>3)<<10 | int(c.G>>3)<<5 | int(c.B>>3)
+			if cells[key] == nil {
+				cells[key] = &cell{}
+			}
+			cl := cells[key]
+			cl.r += int(c.R)
+			cl.g += int(c.G)
+			cl.b += int(c.B)
+			cl.n++
+		}
+	}
+
+	if len(exact) <= 256 {
+		var p color.Palette
+		for c := range exact {
+			p = append(p, c)
+		}
+		return p, true
+	}
+
+	ranked := make([]*cell, 0, len(cells))
+	for _, cl := range cells {
+		ranked = append(ranked, cl)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		return ranked[i].n > ranked[j].n
+	})
+	var p color.Palette
+	for _, cl := range ranked[:min(256, len(ranked))] {
+		p = append(p, color.RGBA{uint8(cl.r / cl.n), uint8(cl.g / cl.n), uint8(cl.b / cl.n), 255})
+	}
+
+	return p, false
+}
+
+// Writes frames as an animated GIF with a palette taken from the first frame,
+// dithering when that palette cannot hold every color. Delay is the time each
+// frame is shown in hundredths of a second. A filename of "-" writes to
+// standard output.
+func EncodeGIF(filename string, frames []image.Image, delay int) error {
+	if len(frames) == 0 {
+		return errors.New("An animation needs at least one frame.")
+	}
+
+	palette, exact := quantizePalette(frames[0])
+	anim := &gif.GIF{}
+	for _, frame := range frames {
+		anim.Image = append(anim.Image, palettedFrame(frame, palette, exact))
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	return writeGIF(filename, anim)
+}
+
+// Writes frames as an animated GIF like EncodeGIF, except that every frame
+// has a palette of its own and is shown for its own delay. The animation
+// repeats loopCount times as in gif.GIF.
+func EncodeGIFFrames(filename string, frames []image.Image, delays []int, loopCount int) error {
+	if len(frames) == 0 {
+		return errors.New("An animation needs at least one frame.")
+	}
+	if len(delays) != len(frames) {
+		return errors.New("Every frame of an animation needs a delay.")
+	}
+
+	anim := &gif.GIF{LoopCount: loopCount}
+	for i, frame := range frames {
+		palette, exact := quantizePalette(frame)
+		anim.Image = append(anim.Image, palettedFrame(frame, palette, exact))
+		anim.Delay = append(anim.Delay, delays[i])
+	}
+
+	return writeGIF(filename, anim)
+}
+
+// Converts a frame to the palette, dithering when it is not exact.
+func palettedFrame(frame image.Image, palette color.Palette, exact bool) *image.Paletted {
+	b := frame.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette)
+	if exact {
+		draw.Draw(paletted, paletted.Bounds(), frame, b.Min, draw.Src)
+	} else {
+		draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), frame, b.Min)
+	}
+	return paletted
+}
+
+func writeGIF(filename string, anim *gif.GIF) error {
+	file, err := createOutput(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return gif.EncodeAll(file, anim)
+}
+
+// Decodes every frame of a GIF. A filename of "-" reads from standard input.
+func DecodeGIF(filename string) (*gif.GIF, error) {
+	var file io.ReadCloser = os.Stdin
+	if filename != "-" {
+		var err error
+		file, err = os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+	}
+
+	return gif.DecodeAll(file)
+}
+
+// The frames of an animated GIF as they are shown, each drawn over what the
+// disposal of the frames before it left on the canvas.
+func GIFFrames(anim *gif.GIF) []image.Image {
+	bounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	for _, frame := range anim.Image {
+		bounds = bounds.Union(frame.Bounds())
+	}
+
+	canvas := image.NewRGBA(bounds)
+	frames := make([]image.Image, 0, len(anim.Image))
+	for i, frame := range anim.Image {
+		var disposal byte
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			draw.Draw(previous, bounds, canvas, bounds.Min, draw.Src)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		shown := image.NewRGBA(bounds)
+		draw.Draw(shown, bounds, canvas, bounds.Min, draw.Src)
+		frames = append(frames, shown)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return frames
+}
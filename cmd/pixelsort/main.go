@@ -0,0 +1,817 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/Yochien/pixelsort"
+	"rsc.io/getopt"
+)
+
+// The image format implied by the extension of a file.
+func formatFromPath(path string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// Replaces the extension of a file with one for format.
+func withFormat(path, format string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "." + format
+}
+
+// Appends suffix to the name of a file, before its extension.
+func withSuffix(path, suffix string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + suffix + ext
+}
+
+// Records whether a flag was given on the command line. getopt sets flag
+// values directly, so flag.Visit never sees the flags it parses.
+type passedValue struct {
+	flag.Value
+	passed bool
+}
+
+func (v *passedValue) Set(s string) error {
+	v.passed = true
+	return v.Value.Set(s)
+}
+
+func (v *passedValue) String() string {
+	if v.Value == nil {
+		return ""
+	}
+	return v.Value.String()
+}
+
+// Wraps the value of the named flag to record whether it was given.
+func trackPassed(name string) *passedValue {
+	f := flag.Lookup(name)
+	v := &passedValue{Value: f.Value}
+	f.Value = v
+	return v
+}
+
+// Extensions of the images sorted by --batch.
+var batchFormats = map[string]bool{
+	"bmp":  true,
+	"gif":  true,
+	"jpg":  true,
+	"jpeg": true,
+	"png":  true,
+	"tif":  true,
+	"tiff": true,
+	"webp": true,
+}
+
+// Names of the files the parts of a split image are saved to, by axis.
+var splitHalvesNames = map[string][]string{
+	"horizontal": {"left", "right"},
+	"vertical":   {"top", "bottom"},
+}
+
+var splitThirdsNames = map[string][]string{
+	"horizontal": {"left", "middle", "right"},
+	"vertical":   {"top", "middle", "bottom"},
+}
+
+// The values an --output-template can refer to.
+type OutputTemplateData struct {
+	// Directory and extension of -o.
+	Dir string
+	Ext string
+	// Name of the input file without its directory or extension.
+	Stem           string
+	SpanType       string
+	LowerThreshold int
+	UpperThreshold int
+	MinSpanLength  int
+	MaxSpanLength  int
+	MaskType       string
+	SortKey        string
+	Reverse        bool
+	Invert         bool
+	Angle          float64
+	Seed           int64
+}
+
+func main() {
+	err := run()
+	if errors.Is(err, pixelsort.ErrUnimplementedSpanType) {
+		fmt.Println(err.Error())
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	flag.Usage = func() {
+		w := flag.CommandLine.Output()
+
+		fmt.Fprintf(w, "Usage: [options] <filename>\n       [options] -B <directory>\nA filename of - reads from standard input.\nOptions:\n")
+		getopt.PrintDefaults()
+	}
+
+	var opts pixelsort.Options
+	flag.IntVar(&opts.LowerThreshold, "l", pixelsort.LowThreshold, "Lower perceived luminance `threshold` in [0, 65535] when generating a mask for the image.")
+	flag.IntVar(&opts.UpperThreshold, "u", pixelsort.HighThreshold, "Upper perceived luminance `threshold` in [0, 65535] when generating a mask for the image.")
+	lowerpassed, upperpassed := trackPassed("l"), trackPassed("u")
+	normalizethresholds := flag.Bool("normalize-thresholds", false, "Read -l and -u on the 8-bit scale of [0, 255] instead of the 16-bit scale of [0, 65535].")
+	flag.BoolVar(&opts.AutoThreshold, "auto-threshold", false, "Pick the luminance thresholds from the image histogram instead of -l and -u.")
+	flag.Float64Var(&opts.AutoLow, "auto-lo", 0.1, "Fraction of the pixels darker than the lower threshold picked by --auto-threshold.")
+	flag.Float64Var(&opts.AutoHigh, "auto-hi", 0.9, "Fraction of the pixels darker than the upper threshold picked by --auto-threshold.")
+	flag.IntVar(&opts.MinSpanLength, "s", 2, "The minimum allowed length of span that should be sorted.")
+	flag.IntVar(&opts.MaxSpanLength, "S", 0, "Split horizontal and vertical spans longer than this into pieces, 0 for no limit.")
+	flag.Var(&opts.SpanType, "t", "The type of sorting to do, 0: horizontal, 1: vertical, 2: diagonal, 3: radial, 4: spiral, or one of: fan, texture-aware, ellipse, grid, bresenham-line, watershed-boundary, polygon, diamond, geodesic, quad-tree, text-line, flow.")
+	twopass := flag.Bool("two-pass", false, "Sort horizontally and then vertically on the result, ignoring -t.")
+	adaptivemask := flag.Bool("adaptive-mask", false, "Generate the mask of the vertical pass of --two-pass and of each --repeat from the image sorted so far.")
+	repeat := flag.Int("R", 1, "Run the sort this many times, each on the result of the last.")
+	flag.Float64Var(&opts.Angle, "a", 0, "Angle in degrees, clockwise from horizontal, of the lines horizontal spans follow.")
+	flag.BoolVar(&opts.Zigzag, "z", false, "Run horizontal spans on odd rows right to left.")
+	flag.IntVar(&opts.TileWidth, "tile-width", 0, "Width in pixels of tiles horizontal spans are sorted within independently, 0 for the image width.")
+	flag.IntVar(&opts.TileHeight, "tile-height", 0, "Height in pixels of tiles horizontal spans are sorted within independently, 0 for the image height.")
+	flag.IntVar(&opts.Center.X, "center-x", -1, "Column of the center of radial spans, defaults to the image center.")
+	flag.IntVar(&opts.Center.Y, "center-y", -1, "Row of the center of radial spans, defaults to the image center.")
+	flag.Float64Var(&opts.SpiralSpacing, "spiral-spacing", 2, "Distance in pixels between the arms of spiral spans.")
+	maskfile := flag.String("M", "", "A black and white mask image to sort with instead of generating one, -l, -u, and -i are ignored.")
+	maskcombine := flag.String("mask-combine", "", "A second black and white mask image combined with the mask by --mask-op.")
+	var maskop pixelsort.MaskOp
+	flag.Var(&maskop, "mask-op", "How --mask-combine is combined with the mask, one of: and, or, xor, subtract.")
+	keepmask := flag.Bool("m", false, "Produce an output file for the generated mask.")
+	flag.BoolVar(&opts.Invert, "i", false, "Invert the mask for sortable image areas.")
+	flag.BoolVar(&opts.Reverse, "r", false, "Reverse the sorting direction.")
+	flag.IntVar(&opts.Workers, "w", runtime.NumCPU(), "Number of spans to sort concurrently.")
+	preserveformat := flag.Bool("p", false, "Produce output in the same image format of the provided input.")
+	output := flag.String("o", "./output/out.png", "The file to write the sorted image to, its extension picks the format. Use - for standard output.")
+	opacity := flag.Float64("O", 1.0, "Opacity in [0, 1] of the sorted image over the original.")
+	huerotate := flag.Float64("hue-rotate", 0, "Degrees to shift the hue of every pixel of the output by, after blending with -O.")
+	invertoutput := flag.Bool("invert-output", false, "Invert the colors of the output, after blending with -O.")
+	animate := flag.Bool("animate", false, "Write an animated GIF revealing the sorted spans a few at a time instead of a single image.")
+	animatestep := flag.Int("animate-step", 32, "Number of spans sorted in each frame of the animation.")
+	gifdelay := flag.Int("gif-delay", -1, "Time in hundredths of a second each frame of a sorted GIF is shown, negative to keep the delays of the input.")
+	timeout := flag.Duration("timeout", 0, "Give up sorting an image after this long, such as 30s, 0 for no limit.")
+	exportspans := flag.String("export-spans", "", "Also write the spans that were sorted to this file as JSON. Only horizontal, vertical, diagonal and radial spans can be exported, and not with --two-pass.")
+	importspans := flag.String("import-spans", "", "Sort the spans in this JSON file from --export-spans instead of generating a mask and spans. The span type, angle and radial center are read from the file.")
+	verbose := flag.Bool("v", false, "Report the progress of the sort on standard error.")
+	dryrun := flag.Bool("n", false, "Print statistics about the spans that would be sorted instead of writing any output.")
+	batch := flag.String("B", "", "Sort every JPEG, PNG, GIF, TIFF, BMP, and WebP image in this directory instead of a single file, writing results beside -o.")
+	suffix := flag.String("suffix", "_sorted", "Appended to the name of each image sorted by --batch.")
+	jpegquality := flag.Int("q", 95, "Quality in [1, 100] of JPEG output.")
+	webpquality := flag.Float64("webp-quality", 90, "Quality in [0, 100] of lossy WebP output.")
+	webplossless := flag.Bool("webp-lossless", false, "Write WebP output losslessly.")
+	pngcompression := flag.String("png-compression", "default", "Compression of PNG output, one of: none, fast, default, best.")
+	tiffcompressionname := flag.String("tiff-compression", "none", "Compression of TIFF output, one of: none, deflate. The TIFF encoder can not write lzw or packbits.")
+	outputformat := flag.String("format", "", "Format of the output, one of: png, jpeg, tiff, bmp, webp. Defaults to the output extension, or the input format when writing to standard output.")
+	outputtemplate := flag.String("output-template", "", "Go template the output path is made from instead of -o, such as {{.Dir}}/{{.Stem}}_t{{.SpanType}}.{{.Ext}}. See OutputTemplateData for the fields.")
+	maskoutput := flag.String("mask-output", "", "The file -m writes the mask to, defaults to mask.<format> beside the output.")
+	sortkey := flag.String("sort-key", "hue", "The pixel property to sort by, a comparator or one of: none, color-distance, hue-with-grey-neutral, hue-then-luma, distance-from-white, dominant-wavelength, harmonic-mean, geometric-mean, luma-contrast-enhancement, luma-periodic, oklab-hue, pca, reference-distance, rgb-interleave, saturation-contrast, spatial-hue, compressed-size, run-length-encode, gradient-sign, mirror-second-half.")
+	flag.StringVar(sortkey, "c", "hue", "The pixel comparator to sort by, one of: hue, luminance, saturation, oklab-l, oklab-c, red, green, blue, alpha.")
+	sortcolor := flag.String("sort-color", "", "Sort by distance to this color, as rrggbb, closest first. Selects the color-distance sort key.")
+	greythreshold := flag.Float64("grey-saturation-threshold", 0.1, "Saturation below which pixels are treated as grey by the hue-with-grey-neutral sort key.")
+	greyposition := flag.Float64("grey-key-position", 0.5, "Sort key in [0, 1] given to grey pixels by the hue-with-grey-neutral sort key.")
+	referenceimage := flag.String("reference-image", "", "Image compared against by the reference-distance sort key, must match the input dimensions.")
+	saturationcontrastradius := flag.Int("saturation-contrast-radius", 2, "Radius of the neighborhood measured by the saturation-contrast sort key.")
+	lumaperiod := flag.Float64("luma-period", 0.25, "Luma difference between repeating bands of the luma-periodic sort key.")
+	huebuckets := flag.Int("hue-buckets", 12, "Number of hue ranges the hue-then-luma sort key groups pixels into.")
+	spatialalpha := flag.Float64("spatial-alpha", 0.5, "Weight in [0, 1] of hue against horizontal position in the spatial-hue sort key.")
+	flag.IntVar(&opts.SkipEveryN, "N", 1, "Only sort every nth span, leaving the rest in their original order.")
+	flag.IntVar(&opts.RotateSpanBy, "rotate-span-by", 0, "Cyclically shift the pixels of every span by this many positions after sorting.")
+	flag.IntVar(&opts.RotateSpanByIndex, "rotate-span-by-index", 0, "Cyclically shift the pixels of span i by i modulo this value after sorting.")
+	flag.Float64Var(&opts.ScrambleFraction, "scramble-fraction", 0, "Fraction between 0 and 1 of each span, from its start, to shuffle after sorting.")
+	spanmode := flag.String("span-mode", "sort", "What is done with the pixels of each span: sort, average to fill it with its mean color, or gradient to blend from its first to its last pixel.")
+	flag.Float64Var(&opts.Randomize, "randomize", 0, "Chance between 0 and 1 of shuffling adjacent pixels of each span after sorting, also the fraction of pairs shuffled. Uses --seed.")
+	flag.IntVar(&opts.MaskDilate, "mask-dilate", 0, "Radius in pixels the white regions of the generated mask are grown by, after erosion.")
+	flag.IntVar(&opts.MaskErode, "mask-erode", 0, "Radius in pixels the white regions of the generated mask are shrunk by.")
+	flag.Float64Var(&opts.MaskBlur, "mask-blur", 0, "Radius in pixels of a gaussian blur softening the boundaries of the generated mask, 0 for none.")
+	flag.BoolVar(&opts.MaskPreMedianFilter, "mask-pre-median-filter", false, "Median filter the image before generating the mask to avoid spans from single pixel noise.")
+	flag.BoolVar(&opts.ChannelSort, "channel-sort", false, "Sort the red, green, and blue channels of each span independently instead of whole pixels.")
+	channelswap := flag.String("channel-swap", "", "Remap the color channels before sorting, a permutation of RGB such as BGR.")
+	flag.BoolVar(&opts.PreInvertHue, "pre-invert-hue", false, "Mirror the hue of every pixel in a span across the color wheel before sorting.")
+	flag.BoolVar(&opts.SpectralNormalize, "spectral-normalize", false, "Reverse spans whose hue decreases along their length so every span runs from low to high hue.")
+	flag.IntVar(&opts.FanSourceX, "fan-source-x", -1, "The column on the top edge that fan spans radiate from, defaults to the center.")
+	flag.IntVar(&opts.TextureRadius, "texture-radius", 2, "Radius of the neighborhood used to measure texture for texture-aware spans.")
+	flag.IntVar(&opts.TextureMaxShift, "texture-max-shift", 8, "Maximum distance in pixels a texture-aware span boundary may move.")
+	flag.Float64Var(&opts.EllipseA, "ellipse-a", 0, "Semi-major axis of the ellipse shaping ellipse spans, defaults to half the image width.")
+	flag.Float64Var(&opts.EllipseB, "ellipse-b", 0, "Semi-minor axis of the ellipse shaping ellipse spans, defaults to half the image height.")
+	flag.Float64Var(&opts.EllipseAngle, "ellipse-angle", 0, "Rotation in degrees of the ellipse shaping ellipse spans.")
+	flag.IntVar(&opts.LineStart.X, "line-start-x", 0, "Column the line of bresenham-line spans starts at.")
+	flag.IntVar(&opts.LineStart.Y, "line-start-y", 0, "Row the line of bresenham-line spans starts at.")
+	flag.IntVar(&opts.LineEnd.X, "line-end-x", -1, "Column the line of bresenham-line spans ends at, defaults to the right edge.")
+	flag.IntVar(&opts.LineEnd.Y, "line-end-y", -1, "Row the line of bresenham-line spans ends at, defaults to the bottom edge.")
+	flag.IntVar(&opts.LineSpacing, "line-spacing", 0, "Distance in pixels between parallel bresenham-line spans, 0 sorts along the single line.")
+	flag.IntVar(&opts.DiamondCenter.X, "diamond-cx", -1, "Column of the center of diamond spans, defaults to the image center.")
+	flag.IntVar(&opts.DiamondCenter.Y, "diamond-cy", -1, "Row of the center of diamond spans, defaults to the image center.")
+	flag.IntVar(&opts.GeodesicPaths, "geodesic-paths", 64, "Number of paths traced across the image by geodesic spans.")
+	flag.IntVar(&opts.FlowMaxLength, "flow-max-length", 64, "Length in pixels at which the paths of flow spans stop following the image gradient.")
+	flag.IntVar(&opts.QuadMaxDepth, "quad-max-depth", 8, "Maximum number of times quad-tree spans split the image.")
+	flag.Float64Var(&opts.QuadVarianceThreshold, "quad-variance-threshold", 0.005, "Luminance variance, with luminance in [0, 1], below which quad-tree cells stop splitting.")
+	flag.Float64Var(&opts.TextLineDeviation, "text-line-deviation", 1, "Standard deviations a row's average luminance must stray from the image average to be sorted by text-line spans.")
+	insertmidpoint := flag.Bool("insert-midpoint-color", false, "Insert the insert color into the middle of every span after sorting.")
+	insertcolor := flag.String("insert-color", "ffffff", "Color as rrggbb inserted into spans by --insert-midpoint-color.")
+	diff := flag.Bool("diff", false, "Also save an image of how much the luminance of each pixel changed, named after the output with _diff.")
+	splithalves := flag.String("output-split-halves", "", "Also save the output cut in half, horizontal for left and right or vertical for top and bottom.")
+	splitthirds := flag.String("output-split-thirds", "", "Also save the output cut in thirds, horizontal for side by side or vertical for stacked parts.")
+	region := flag.String("region", "", "Only sort the rectangle \"x,y,w,h\" of the image, leaving the rest untouched.")
+	polygon := flag.String("polygon", "", "Vertices of the region sorted by polygon spans, as \"x1,y1:x2,y2:...:xN,yN\".")
+	flag.IntVar(&opts.GridCellWidth, "grid-cell-width", 64, "Width in pixels of each cell of the grid span type.")
+	flag.IntVar(&opts.GridCellHeight, "grid-cell-height", 64, "Height in pixels of each cell of the grid span type.")
+	flag.StringVar(&opts.GridCellDirectionMode, "grid-cell-direction-mode", "checkerboard", "How the sort direction of each grid cell is chosen, one of: checkerboard, random, horizontal, vertical.")
+	flag.StringVar(&opts.MaskType, "mask-type", "luminance", "The method used to generate the mask, one of: luminance, noisy-stripes, canny, sdf, motion-blur, contour-band, near-edge, edge, hue, saturation, noise, gradient, radial-gradient, voronoi.")
+	flag.IntVar(&opts.StripeSpacing, "stripe-spacing", 32, "Distance in pixels between the start of consecutive mask stripes.")
+	flag.IntVar(&opts.StripeWidth, "stripe-width", 16, "Width in pixels of each mask stripe.")
+	flag.Float64Var(&opts.StripeNoiseDensity, "stripe-noise-density", 0.1, "Fraction of mask pixels flipped by noise in the noisy-stripes mask, between 0 and 1.")
+	flag.Float64Var(&opts.CannyLow, "canny-low", 0.2, "Gradient magnitude below which pixels are never edges in the canny mask.")
+	flag.Float64Var(&opts.CannyHigh, "canny-high", 0.5, "Gradient magnitude above which pixels are always edges in the canny mask.")
+	flag.IntVar(&opts.CannyBlurRadius, "canny-blur-radius", 2, "Radius of the gaussian blur applied before canny edge detection.")
+	flag.IntVar(&opts.SdfInnerRadius, "sdf-inner-radius", 4, "Distance from the nearest canny edge where the sdf mask starts.")
+	flag.IntVar(&opts.SdfOuterRadius, "sdf-outer-radius", 16, "Distance from the nearest canny edge where the sdf mask ends.")
+	flag.Float64Var(&opts.HueLow, "hue-low", 0, "Lowest hue in degrees selected by the hue mask, wrapping around through 0 when above --hue-high.")
+	flag.Float64Var(&opts.HueHigh, "hue-high", 60, "Highest hue in degrees selected by the hue mask.")
+	flag.Float64Var(&opts.SaturationLow, "sat-low", 0.2, "Lowest saturation in [0, 1] selected by the saturation mask.")
+	flag.Float64Var(&opts.SaturationHigh, "sat-high", 1.0, "Highest saturation in [0, 1] selected by the saturation mask.")
+	flag.Float64Var(&opts.GradientAngle, "gradient-angle", 0, "Direction in degrees, clockwise from horizontal, the gradient mask increases along.")
+	flag.Float64Var(&opts.GradientLow, "gradient-low", 0, "Lowest gradient value in [0, 1] selected by the gradient and radial-gradient masks.")
+	flag.Float64Var(&opts.GradientHigh, "gradient-high", 0.5, "Highest gradient value in [0, 1] selected by the gradient and radial-gradient masks.")
+	flag.Float64Var(&opts.NoiseScale, "noise-scale", 64, "Size in pixels of the features of the noise mask.")
+	flag.Float64Var(&opts.NoiseLow, "noise-low", 0.5, "Lowest Perlin noise value in [0, 1] selected by the noise mask.")
+	flag.Float64Var(&opts.NoiseHigh, "noise-high", 1.0, "Highest Perlin noise value in [0, 1] selected by the noise mask.")
+	flag.Int64Var(&opts.NoiseSeed, "noise-seed", 0, "Seed of the Perlin noise of the noise mask.")
+	flag.IntVar(&opts.VoronoiSeeds, "voronoi-seeds", 32, "Number of cells in the voronoi mask, every other one white.")
+	flag.Int64Var(&opts.VoronoiSeed, "voronoi-seed", 0, "Seed of the random cell centers of the voronoi mask.")
+	flag.Float64Var(&opts.EdgeThreshold, "edge-threshold", 0.25, "Sobel gradient magnitude of luminance in [0, 1] above which the edge mask is white.")
+	flag.IntVar(&opts.NearEdgeDistance, "near-edge-distance", 4, "Distance in pixels from the nearest canny edge within which the near-edge mask is white.")
+	flag.IntVar(&opts.MotionAngle, "motion-angle", 0, "Direction in degrees of the blur applied by the motion-blur mask.")
+	flag.IntVar(&opts.MotionLength, "motion-length", 15, "Length in pixels of the blur applied by the motion-blur mask.")
+	flag.IntVar(&opts.ContourCount, "contour-count", 8, "Number of evenly spaced luminance contours in the contour-band mask.")
+	flag.IntVar(&opts.ContourWidth, "contour-width", 6, "Width in pixels of the band around each contour in the contour-band mask.")
+	flag.Int64Var(&opts.Seed, "seed", 0, "Seed for the random number generator used by randomized options.")
+
+	getopt.Aliases(
+		"l", "lower-threshold",
+		"u", "upper-threshold",
+		"s", "minimum-span-length",
+		"S", "max-span-length",
+		"t", "span-type",
+		"a", "angle",
+		"z", "zigzag",
+		"m", "keep-mask",
+		"i", "invert",
+		"r", "reverse",
+		"w", "workers",
+		"p", "preserve-format",
+		"c", "comparator",
+		"M", "mask-file",
+		"o", "output",
+		"q", "jpeg-quality",
+		"O", "opacity",
+		"B", "batch",
+		"n", "dry-run",
+		"v", "verbose",
+		"N", "skip-every-n",
+		"R", "repeat",
+	)
+
+	getopt.Parse()
+	if (*batch == "") == (len(flag.Args()) != 1) {
+		flag.Usage()
+		return nil
+	}
+
+	if *jpegquality < 1 || *jpegquality > 100 {
+		return errors.New("JPEG quality must be between 1 and 100.")
+	}
+	if *opacity < 0 || *opacity > 1 {
+		return errors.New("Opacity must be between 0 and 1.")
+	}
+	if *twopass && *exportspans != "" {
+		return errors.New("Spans of --two-pass can not be exported, as they come from two span types.")
+	}
+	if *webpquality < 0 || *webpquality > 100 {
+		return errors.New("WebP quality must be between 0 and 100.")
+	}
+	compression, ok := pixelsort.PNGCompressionLevels[*pngcompression]
+	if !ok {
+		return fmt.Errorf("unknown PNG compression: %s", *pngcompression)
+	}
+	tiffcompression, ok := pixelsort.TIFFCompressionTypes[*tiffcompressionname]
+	if !ok && slices.Contains(pixelsort.UnwritableTIFFCompressions, *tiffcompressionname) {
+		return fmt.Errorf("TIFF compression %s is not supported: the golang.org/x/image/tiff encoder can only write none and deflate", *tiffcompressionname)
+	}
+	if !ok {
+		return fmt.Errorf("unknown TIFF compression: %s", *tiffcompressionname)
+	}
+	encodeopts := pixelsort.EncodeOptions{
+		JPEGQuality:     *jpegquality,
+		PNGCompression:  compression,
+		WebPQuality:     float32(*webpquality),
+		WebPLossless:    *webplossless,
+		TIFFCompression: tiffcompression,
+	}
+
+	if *normalizethresholds {
+		// Thresholds left at their defaults are already on the 16-bit scale.
+		for threshold, passed := range map[*int]bool{&opts.LowerThreshold: lowerpassed.passed, &opts.UpperThreshold: upperpassed.passed} {
+			if !passed {
+				continue
+			}
+			if *threshold < 0 || *threshold > 255 {
+				return errors.New("Normalized thresholds must be between 0 and 255.")
+			}
+			*threshold *= pixelsort.ThresholdScale
+		}
+	}
+
+	var err error
+	if *polygon != "" {
+		opts.Polygon, err = pixelsort.ParsePolygon(*polygon)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *region != "" {
+		opts.Region, err = pixelsort.ParseRegion(*region)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *importspans != "" {
+		err = pixelsort.ImportSpans(*importspans, &opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	var reference image.Image
+	if *referenceimage != "" {
+		reference, _, err = pixelsort.DecodeImage(*referenceimage)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *insertmidpoint {
+		opts.InsertColor, err = pixelsort.ParseHexColor(*insertcolor)
+		if err != nil {
+			return err
+		}
+	}
+
+	var sortcolorref *color.RGBA
+	if *sortcolor != "" {
+		ref, err := pixelsort.ParseHexColor(*sortcolor)
+		if err != nil {
+			return err
+		}
+		sortcolorref = &ref
+		*sortkey = "color-distance"
+	}
+
+	opts.NormalizeKeys = *sortkey == "luma-contrast-enhancement"
+	if *verbose {
+		opts.Progress = os.Stderr
+	}
+
+	spanmodefn, ok := pixelsort.SpanModes[*spanmode]
+	if !ok {
+		return fmt.Errorf("unknown span mode: %s", *spanmode)
+	}
+
+	// Applies the channel swap to img and works out the mask and sort key it
+	// is sorted with.
+	// The mask img is sorted with: loaded, or generated from img, and then
+	// combined with --mask-combine.
+	makeMask := func(img image.Image, opts pixelsort.Options) (image.Image, error) {
+		var mask image.Image
+		var err error
+		switch {
+		case *importspans != "":
+			// Imported spans are sorted without a mask.
+			return nil, nil
+		case *maskfile != "":
+			mask, err = pixelsort.LoadMask(*maskfile, img.Bounds())
+		default:
+			start := time.Now()
+			mask, err = pixelsort.GenerateMask(img, opts)
+			if err == nil && *verbose {
+				fmt.Fprintf(os.Stderr, "Mask generated: %.2fs\n", time.Since(start).Seconds())
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if *maskcombine != "" {
+			other, err := pixelsort.LoadMask(*maskcombine, img.Bounds())
+			if err != nil {
+				return nil, err
+			}
+			return pixelsort.CombineMasks(mask, other, maskop)
+		}
+		return mask, nil
+	}
+
+	// Sets the sort key or span transform of opts, building keys that depend
+	// on the whole image from img.
+	setKey := func(img image.Image, opts pixelsort.Options) (pixelsort.Options, error) {
+		var err error
+		if transform, ok := pixelsort.SpanTransforms[*sortkey]; ok {
+			opts.Transform = transform
+		} else {
+			opts.Key, err = pixelsort.MakeSortKey(*sortkey, img, pixelsort.SortKeyParams{
+				GreyThreshold:            *greythreshold,
+				GreyPosition:             *greyposition,
+				Reference:                reference,
+				SaturationContrastRadius: *saturationcontrastradius,
+				LumaPeriod:               *lumaperiod,
+				HueBuckets:               *huebuckets,
+				SpatialAlpha:             *spatialalpha,
+				SortColor:                sortcolorref,
+			})
+			if err != nil {
+				return opts, err
+			}
+		}
+		if spanmodefn != nil {
+			opts.Key = nil
+			opts.Transform = spanmodefn
+		}
+		return opts, nil
+	}
+
+	// Prepares opts for sorting img, which has already been sorted before
+	// when again is set. The mask is only rebuilt then with --adaptive-mask.
+	prepareAgain := func(img image.Image, opts pixelsort.Options, again bool) (pixelsort.Options, error) {
+		var err error
+		if !again || (*adaptivemask && opts.Mask != nil && *maskfile == "") {
+			opts.Mask, err = makeMask(img, opts)
+			if err != nil {
+				return opts, err
+			}
+		}
+		return setKey(img, opts)
+	}
+
+	prepare := func(img image.Image) (image.Image, pixelsort.Options, error) {
+		opts := opts
+		var err error
+		if *channelswap != "" {
+			img, err = pixelsort.SwapChannels(img, *channelswap)
+			if err != nil {
+				return nil, opts, err
+			}
+		}
+
+		opts, err = prepareAgain(img, opts, false)
+		if err != nil {
+			return nil, opts, err
+		}
+		return img, opts, nil
+	}
+
+	// Sorts src a single time, reporting how long it took and the spans that
+	// were sorted when verbose.
+	sortPass := func(ctx context.Context, src image.Image, opts pixelsort.Options) (image.Image, []pixelsort.Span, error) {
+		start := time.Now()
+		out, spans, err := pixelsort.SortImageWithSpans(ctx, src, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if *verbose {
+			count, covered, minlen, maxlen, avglen := pixelsort.SpanStats(spans)
+			fmt.Fprintf(os.Stderr, "Sorted %d spans of %d pixels in %.2fs, span length min %d, max %d, average %.2f\n",
+				count, covered, time.Since(start).Seconds(), minlen, maxlen, avglen)
+		}
+		return out, spans, nil
+	}
+
+	sortOnce := func(ctx context.Context, src image.Image, opts pixelsort.Options) (out image.Image, err error) {
+		if *twopass {
+			// Sorts horizontally, then vertically on the result.
+			opts.SpanType = pixelsort.Horizontal
+			out, _, err = sortPass(ctx, src, opts)
+			if err != nil {
+				return nil, err
+			}
+			opts.SpanType = pixelsort.Vertical
+			opts, err = prepareAgain(out, opts, true)
+			if err != nil {
+				return nil, err
+			}
+			out, _, err = sortPass(ctx, out, opts)
+			return out, err
+		}
+		out, spans, err := sortPass(ctx, src, opts)
+		if err != nil {
+			return nil, err
+		}
+		if *exportspans != "" {
+			err = pixelsort.ExportSpans(*exportspans, spans, opts, src.Bounds().Size())
+		}
+		return out, err
+	}
+
+	// Sorts img as many times as asked, each time on the result of the last,
+	// and blends the result over img.
+	sortRepeatedly := func(ctx context.Context, img image.Image, opts pixelsort.Options) (image.Image, error) {
+		var err error
+		out := img
+		for i := range max(*repeat, 1) {
+			iterOpts := opts
+			if i > 0 {
+				iterOpts, err = prepareAgain(out, opts, true)
+				if err != nil {
+					return nil, err
+				}
+			}
+			out, err = sortOnce(ctx, out, iterOpts)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		out = pixelsort.BlendImages(img, out, *opacity)
+		if *huerotate != 0 {
+			out = pixelsort.RotateHue(out, *huerotate)
+		}
+		if *invertoutput {
+			out = pixelsort.InvertImage(out)
+		}
+		return out, nil
+	}
+
+	// Sorts every frame of an animated GIF on its own, writing them as an
+	// animated GIF to output.
+	processGIF := func(ctx context.Context, filename, output string) error {
+		anim, err := pixelsort.DecodeGIF(filename)
+		if err != nil {
+			return err
+		}
+
+		frames := pixelsort.GIFFrames(anim)
+		delays := make([]int, len(frames))
+		for i, frame := range frames {
+			img, opts, err := prepare(frame)
+			if err != nil {
+				return err
+			}
+			frames[i], err = sortRepeatedly(ctx, img, opts)
+			if err != nil {
+				return err
+			}
+			delays[i] = *gifdelay
+			if delays[i] < 0 && i < len(anim.Delay) {
+				delays[i] = anim.Delay[i]
+			}
+		}
+
+		if output != "-" {
+			output = withFormat(output, "gif")
+		}
+		return pixelsort.EncodeGIFFrames(output, frames, delays, anim.LoopCount)
+	}
+
+	// Sorts a single image, writing the result to output and the mask, when
+	// kept, to maskoutput. GIFs are sorted frame by frame.
+	process := func(filename, output, maskoutput string) error {
+		ctx := context.Background()
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+
+		if formatFromPath(filename) == "gif" && !*dryrun && !*animate {
+			return processGIF(ctx, filename, output)
+		}
+
+		img, format, err := pixelsort.DecodeImage(filename)
+		if err != nil {
+			return err
+		}
+		img, opts, err := prepare(img)
+		if err != nil {
+			return err
+		}
+
+		if *dryrun {
+			// The statistics are printed instead.
+			opts.Progress = nil
+			start := time.Now()
+			_, spans, err := pixelsort.SortImageWithSpans(ctx, img, opts)
+			if err != nil {
+				return err
+			}
+			elapsed := time.Since(start)
+
+			b := img.Bounds()
+			count, covered, minlen, maxlen, avglen := pixelsort.SpanStats(spans)
+			fmt.Printf("%s\n", filename)
+			fmt.Printf("  Format: %s\n", format)
+			fmt.Printf("  Pixels: %d\n", b.Dx()*b.Dy())
+			fmt.Printf("  Spans: %d\n", count)
+			fmt.Printf("  Sorted pixels: %d (%.2f%%)\n", covered, 100*float64(covered)/float64(max(1, b.Dx()*b.Dy())))
+			fmt.Printf("  Span length: min %d, max %d, average %.2f\n", minlen, maxlen, avglen)
+			fmt.Printf("  Time: %s\n", elapsed)
+			return nil
+		}
+
+		if *animate {
+			frames, err := pixelsort.SortImageFrames(ctx, img, opts, *animatestep)
+			if err != nil {
+				return err
+			}
+			for i, frame := range frames {
+				frames[i] = pixelsort.BlendImages(img, frame, *opacity)
+				if *huerotate != 0 {
+					frames[i] = pixelsort.RotateHue(frames[i], *huerotate)
+				}
+				if *invertoutput {
+					frames[i] = pixelsort.InvertImage(frames[i])
+				}
+			}
+
+			if output != "-" {
+				output = withFormat(output, "gif")
+			}
+			return pixelsort.EncodeGIF(output, frames, 10)
+		}
+
+		out, err := sortRepeatedly(ctx, img, opts)
+		if err != nil {
+			return err
+		}
+
+		outputformat := *outputformat
+		if output == "-" {
+			if outputformat == "" {
+				outputformat = format
+			}
+		} else if *preserveformat {
+			output = withFormat(output, format)
+		}
+		if outputformat != "" {
+			format = outputformat
+		} else {
+			format = formatFromPath(output)
+		}
+		err = pixelsort.EncodeImage(output, out, format, encodeopts)
+		if err != nil {
+			return err
+		}
+		if *diff {
+			err = pixelsort.EncodeImage(withSuffix(output, "_diff"), pixelsort.DiffImages(img, out), format, encodeopts)
+			if err != nil {
+				return err
+			}
+		}
+		if *splithalves != "" {
+			names, ok := splitHalvesNames[*splithalves]
+			if !ok {
+				return fmt.Errorf("unknown split axis: %s", *splithalves)
+			}
+			first, second, err := pixelsort.SplitImage(out, *splithalves)
+			if err != nil {
+				return err
+			}
+			for i, part := range []image.Image{first, second} {
+				err = pixelsort.EncodeImage(withSuffix(output, "_"+names[i]), part, format, encodeopts)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if *splitthirds != "" {
+			names, ok := splitThirdsNames[*splitthirds]
+			if !ok {
+				return fmt.Errorf("unknown split axis: %s", *splitthirds)
+			}
+			first, second, third, err := pixelsort.SplitImageThirds(out, *splitthirds)
+			if err != nil {
+				return err
+			}
+			for i, part := range []image.Image{first, second, third} {
+				err = pixelsort.EncodeImage(withSuffix(output, "_"+names[i]), part, format, encodeopts)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if *keepmask && opts.Mask != nil {
+			if maskoutput == "" {
+				maskoutput = filepath.Join(filepath.Dir(output), "mask."+format)
+			}
+			err = pixelsort.EncodeImage(maskoutput, opts.Mask, formatFromPath(maskoutput), encodeopts)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var outputtmpl *template.Template
+	if *outputtemplate != "" {
+		outputtmpl, err = template.New("output").Parse(*outputtemplate)
+		if err != nil {
+			return err
+		}
+	}
+	// The path the image sorted from input is written to, made from the
+	// output template when there is one and otherwise fallback.
+	outputPath := func(input, fallback string) (string, error) {
+		if outputtmpl == nil {
+			return fallback, nil
+		}
+		stem := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+		if input == "-" {
+			stem = "stdin"
+		}
+		ext := *outputformat
+		if ext == "" {
+			ext = formatFromPath(*output)
+		}
+
+		var path strings.Builder
+		err := outputtmpl.Execute(&path, OutputTemplateData{
+			Dir:            filepath.Dir(*output),
+			Ext:            ext,
+			Stem:           stem,
+			SpanType:       opts.SpanType.String(),
+			LowerThreshold: opts.LowerThreshold,
+			UpperThreshold: opts.UpperThreshold,
+			MinSpanLength:  opts.MinSpanLength,
+			MaxSpanLength:  opts.MaxSpanLength,
+			MaskType:       opts.MaskType,
+			SortKey:        *sortkey,
+			Reverse:        opts.Reverse,
+			Invert:         opts.Invert,
+			Angle:          opts.Angle,
+			Seed:           opts.Seed,
+		})
+		return path.String(), err
+	}
+
+	if *batch != "" {
+		entries, err := os.ReadDir(*batch)
+		if err != nil {
+			return err
+		}
+
+		files := make(chan string, len(entries))
+		for _, entry := range entries {
+			if !entry.IsDir() && batchFormats[formatFromPath(entry.Name())] {
+				files <- entry.Name()
+			}
+		}
+		close(files)
+
+		var wg sync.WaitGroup
+		var failed atomic.Bool
+		outdir := filepath.Dir(*output)
+		for range max(opts.Workers, 1) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for name := range files {
+					stem := strings.TrimSuffix(name, filepath.Ext(name))
+					output, err := outputPath(name, filepath.Join(outdir, stem+*suffix+filepath.Ext(*output)))
+					if err == nil {
+						err = process(filepath.Join(*batch, name), output, withSuffix(output, "_mask"))
+					}
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "error: %s: %v\n", name, err)
+						failed.Store(true)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if failed.Load() {
+			return errors.New("Some images could not be sorted.")
+		}
+		return nil
+	}
+
+	outputfile, err := outputPath(flag.Args()[0], *output)
+	if err != nil {
+		return err
+	}
+	return process(flag.Args()[0], outputfile, *maskoutput)
+}
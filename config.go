@@ -0,0 +1,158 @@
+package pixelsort
+
+import (
+	"context"
+	"image"
+	"runtime"
+)
+
+// Config holds the settings Sort runs the pipeline with.
+type Config struct {
+	Options
+	ctx context.Context
+}
+
+// Option changes one setting of a Config.
+type Option func(*Config)
+
+// The settings of the command line tool when no flags are given.
+func defaultConfig() Config {
+	hue, _ := MakeComparator("hue")
+	return Config{
+		Options: Options{
+			MaskType:              "luminance",
+			LowerThreshold:        LowThreshold,
+			UpperThreshold:        HighThreshold,
+			AutoLow:               0.1,
+			AutoHigh:              0.9,
+			StripeSpacing:         32,
+			StripeWidth:           16,
+			StripeNoiseDensity:    0.1,
+			CannyLow:              0.2,
+			CannyHigh:             0.5,
+			CannyBlurRadius:       2,
+			SdfInnerRadius:        4,
+			SdfOuterRadius:        16,
+			MotionLength:          15,
+			ContourCount:          8,
+			ContourWidth:          6,
+			NearEdgeDistance:      4,
+			EdgeThreshold:         0.25,
+			HueHigh:               60,
+			SaturationLow:         0.2,
+			SaturationHigh:        1,
+			GradientHigh:          0.5,
+			NoiseScale:            64,
+			NoiseLow:              0.5,
+			NoiseHigh:             1,
+			VoronoiSeeds:          32,
+			SpanType:              Horizontal,
+			MinSpanLength:         2,
+			Center:                image.Pt(-1, -1),
+			SpiralSpacing:         2,
+			FanSourceX:            -1,
+			TextureRadius:         2,
+			TextureMaxShift:       8,
+			LineEnd:               image.Pt(-1, -1),
+			DiamondCenter:         image.Pt(-1, -1),
+			GeodesicPaths:         64,
+			FlowMaxLength:         64,
+			QuadMaxDepth:          8,
+			QuadVarianceThreshold: 0.005,
+			TextLineDeviation:     1,
+			GridCellWidth:         64,
+			GridCellHeight:        64,
+			GridCellDirectionMode: "checkerboard",
+			Key:                   byColor(hue),
+			Workers:               runtime.NumCPU(),
+		},
+		ctx: context.Background(),
+	}
+}
+
+// Sets the lowest perceived luminance, in [0, 65535], the mask sorts.
+func WithLowerThreshold(n int) Option {
+	return func(c *Config) { c.LowerThreshold = n }
+}
+
+// Sets the highest perceived luminance, in [0, 65535], the mask sorts.
+func WithUpperThreshold(n int) Option {
+	return func(c *Config) { c.UpperThreshold = n }
+}
+
+// Swaps the pixels the generated mask sorts with those it leaves alone.
+func WithInvert(invert bool) Option {
+	return func(c *Config) { c.Invert = invert }
+}
+
+// Picks how the mask is generated, such as "luminance" or "edge".
+func WithMaskType(name string) Option {
+	return func(c *Config) { c.MaskType = name }
+}
+
+// Sorts with the given mask instead of generating one.
+func WithMask(mask image.Image) Option {
+	return func(c *Config) { c.Mask = mask }
+}
+
+// Picks the shape of the spans pixels are sorted along.
+func WithSpanType(t SpanType) Option {
+	return func(c *Config) { c.SpanType = t }
+}
+
+// Leaves spans shorter than n pixels unsorted.
+func WithMinSpanLength(n int) Option {
+	return func(c *Config) { c.MinSpanLength = n }
+}
+
+// Splits spans longer than n pixels, or never when n is 0.
+func WithMaxSpanLength(n int) Option {
+	return func(c *Config) { c.MaxSpanLength = n }
+}
+
+// Sorts pixels by the value comparator gives their color.
+func WithComparator(comparator PixelComparator) Option {
+	return func(c *Config) { c.Key = byColor(comparator) }
+}
+
+// Sorts pixels by the value key gives their color and position.
+func WithSortKey(key SortKeyFunc) Option {
+	return func(c *Config) { c.Key = key }
+}
+
+// Reverses the order pixels are sorted in.
+func WithReverse(reverse bool) Option {
+	return func(c *Config) { c.Reverse = reverse }
+}
+
+// Sorts spans on n goroutines at once.
+func WithWorkers(n int) Option {
+	return func(c *Config) { c.Workers = n }
+}
+
+// Only sorts the pixels inside r.
+func WithRegion(r image.Rectangle) Option {
+	return func(c *Config) { c.Region = r }
+}
+
+// Stops sorting early with the error of ctx once it is done.
+func WithContext(ctx context.Context) Option {
+	return func(c *Config) { c.ctx = ctx }
+}
+
+// Replaces every setting at once, for options without a constructor of
+// their own.
+func WithOptions(opts Options) Option {
+	return func(c *Config) { c.Options = opts }
+}
+
+// Sorts an image with the defaults of the command line tool changed by opts,
+// applied in order.
+func Sort(img image.Image, opts ...Option) (image.Image, error) {
+	config := defaultConfig()
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return SortImageContext(config.ctx, img, config.Options)
+}
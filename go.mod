@@ -1,8 +1,9 @@
-module pixelsort
+module github.com/Yochien/pixelsort
 
 go 1.23.4
 
 require (
+	github.com/chai2010/webp v1.4.0
 	golang.org/x/image v0.23.0
 	rsc.io/getopt v0.0.0-20170811000552-20be20937449
 )
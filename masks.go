@@ -0,0 +1,1006 @@
+package pixelsort
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+)
+
+// Reads a mask from an image file, treating pixels with a green channel above
+// half intensity as white. The mask must have the given bounds.
+func LoadMask(filename string, bounds image.Rectangle) (image.Image, error) {
+	img, _, err := DecodeImage(filename)
+	if err != nil {
+		return nil, err
+	}
+	if img.Bounds() != bounds {
+		return nil, fmt.Errorf("mask bounds %v do not match image bounds %v", img.Bounds(), bounds)
+	}
+
+	mask := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, g, _, _ := img.At(x, y).RGBA(); g > 32768 {
+				mask.Set(x, y, RGBAWhite)
+			} else {
+				mask.Set(x, y, RGBABlack)
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+func invertMask(mask image.Image) image.Image {
+	b := mask.Bounds()
+	out := image.NewRGBA(b)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if mask.At(x, y) == RGBAWhite {
+				out.Set(x, y, RGBABlack)
+			} else {
+				out.Set(x, y, RGBAWhite)
+			}
+		}
+	}
+
+	return out
+}
+
+// Vertical stripes of the given width, repeating every spacing pixels.
+func generateStripeMask(bounds image.Rectangle, spacing int, width int) (image.Image, error) {
+	if spacing < 1 || width < 0 {
+		return nil, errors.New("Stripe spacing must be positive and stripe width must not be negative.")
+	}
+
+	mask := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if (x-bounds.Min.X)%spacing < width {
+				mask.Set(x, y, RGBAWhite)
+			} else {
+				mask.Set(x, y, RGBABlack)
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+// Uniformly distributed grey noise, used as a source of randomness by masks
+// that threshold it.
+func generateNoiseImage(bounds image.Rectangle, rng *rand.Rand) image.Image {
+	noise := image.NewGray16(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			noise.SetGray16(x, y, color.Gray16{uint16(rng.UintN(65536))})
+		}
+	}
+
+	return noise
+}
+
+// https://mrl.cs.nyu.edu/~perlin/noise/
+type perlinNoise struct {
+	perm [512]int
+}
+
+func newPerlinNoise(seed int64) *perlinNoise {
+	var p perlinNoise
+	rng := rand.New(rand.NewPCG(uint64(seed), 0))
+	for i, v := range rng.Perm(256) {
+		p.perm[i] = v
+		p.perm[i+256] = v
+	}
+	return &p
+}
+
+func perlinFade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func perlinLerp(t, a, b float64) float64 {
+	return a + t*(b-a)
+}
+
+// Unit gradients between the axes, so no edge of the lattice is flat.
+var perlinGradients [8][2]float64
+
+func init() {
+	for i := range perlinGradients {
+		angle := (float64(i) + 0.5) * math.Pi / 4
+		perlinGradients[i] = [2]float64{math.Cos(angle), math.Sin(angle)}
+	}
+}
+
+func perlinGrad(hash int, x, y float64) float64 {
+	g := perlinGradients[hash&7]
+	return g[0]*x + g[1]*y
+}
+
+// Noise at (x, y) in [-1, 1].
+func (p *perlinNoise) at(x, y float64) float64 {
+	xf, yf := math.Floor(x), math.Floor(y)
+	xi, yi := int(xf)&255, int(yf)&255
+	x, y = x-xf, y-yf
+	u, v := perlinFade(x), perlinFade(y)
+
+	aa := p.perm[p.perm[xi]+yi]
+	ab := p.perm[p.perm[xi]+yi+1]
+	ba := p.perm[p.perm[xi+1]+yi]
+	bb := p.perm[p.perm[xi+1]+yi+1]
+
+	return perlinLerp(v,
+		perlinLerp(u, perlinGrad(aa, x, y), perlinGrad(ba, x-1, y)),
+		perlinLerp(u, perlinGrad(ab, x, y-1), perlinGrad(bb, x-1, y-1)))
+}
+
+// Sums octaves of noise at doubling frequencies and halving amplitudes,
+// hiding the lattice of a single octave. In [-1, 1].
+func (p *perlinNoise) fractal(x, y float64, octaves int) float64 {
+	var sum, total float64
+	amplitude := 1.0
+	for range octaves {
+		sum += amplitude * p.at(x, y)
+		total += amplitude
+		x, y = x*2, y*2
+		amplitude /= 2
+	}
+	return sum / total
+}
+
+// Marks pixels where Perlin noise, rescaled to [0, 1], lies in [lo, hi]. The
+// scale is the size in pixels of the features of the noise, which starts at
+// the top left of b.
+func generateNoiseMask(b image.Rectangle, scale float64, lo, hi float64, seed int64, invert bool) (image.Image, error) {
+	if scale <= 0 {
+		return nil, errors.New("Noise scale must be positive.")
+	}
+	if lo > hi {
+		return nil, errors.New("Low noise threshold must be less than high noise threshold.")
+	}
+	if lo < 0 || hi > 1 {
+		return nil, errors.New("Noise thresholds must be between 0 and 1.")
+	}
+
+	noise := newPerlinNoise(seed)
+	mask := image.NewRGBA(b)
+
+	for y := range b.Dy() {
+		for x := range b.Dx() {
+			n := max(0, min(1, (noise.fractal(float64(x)/scale, float64(y)/scale, 3)+1)/2))
+			if (n >= lo && n <= hi) != invert {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBAWhite)
+			} else {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBABlack)
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+// Splits the image into the Voronoi cells of nSeeds random points, the cells
+// of every other point being white. Distances are compared squared.
+func generateVoronoiMask(img image.Image, nSeeds int, seed int64, invert bool) (image.Image, error) {
+	if nSeeds < 1 {
+		return nil, errors.New("A Voronoi mask needs at least one seed.")
+	}
+
+	b := img.Bounds()
+	mask := image.NewRGBA(b)
+	if b.Empty() {
+		return mask, nil
+	}
+	rng := rand.New(rand.NewPCG(uint64(seed), 0))
+	seeds := make([]image.Point, nSeeds)
+	for i := range seeds {
+		seeds[i] = image.Pt(b.Min.X+rng.IntN(b.Dx()), b.Min.Y+rng.IntN(b.Dy()))
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			nearest, best := 0, math.MaxInt
+			for i, s := range seeds {
+				dx, dy := x-s.X, y-s.Y
+				if d := dx*dx + dy*dy; d < best {
+					nearest, best = i, d
+				}
+			}
+			if (nearest%2 == 0) != invert {
+				mask.Set(x, y, RGBAWhite)
+			} else {
+				mask.Set(x, y, RGBABlack)
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+// Flips the stripe mask wherever the noise falls below noiseDensity, so a
+// density of 0 keeps the plain stripes and 1 inverts them entirely.
+func combineStripesAndNoise(stripes, noise image.Image, noiseDensity float64) image.Image {
+	b := stripes.Bounds()
+	out := image.NewRGBA(b)
+	cutoff := noiseDensity * 65536
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			n, _, _, _ := noise.At(x, y).RGBA()
+			flip := float64(n) < cutoff
+			white := stripes.At(x, y) == RGBAWhite
+			if white != flip {
+				out.Set(x, y, RGBAWhite)
+			} else {
+				out.Set(x, y, RGBABlack)
+			}
+		}
+	}
+
+	return out
+}
+
+// The perceived luminance of every pixel, indexed as [y][x] from the image's
+// top left corner.
+func luminanceMap(img image.Image) [][]float64 {
+	b := img.Bounds()
+	luminance := make([][]float64, b.Dy())
+	for y := range b.Dy() {
+		luminance[y] = make([]float64, b.Dx())
+		for x := range b.Dx() {
+			luminance[y][x] = getPerceivedLuminance(img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+
+	return luminance
+}
+
+// Replaces each pixel with whichever pixel in its 3x3 neighborhood has the
+// median luminance, removing isolated specks of noise.
+func medianFilter3x3(img image.Image) image.Image {
+	b := img.Bounds()
+	luminance := luminanceMap(img)
+	out := image.NewRGBA64(b)
+	neighbors := make([]image.Point, 0, 9)
+	for y := range b.Dy() {
+		for x := range b.Dx() {
+			neighbors = neighbors[:0]
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx := max(0, min(b.Dx()-1, x+dx))
+					ny := max(0, min(b.Dy()-1, y+dy))
+					neighbors = append(neighbors, image.Pt(nx, ny))
+				}
+			}
+			sort.Slice(neighbors, func(i, j int) bool {
+				return luminance[neighbors[i].Y][neighbors[i].X] < luminance[neighbors[j].Y][neighbors[j].X]
+			})
+			median := b.Min.Add(neighbors[4])
+			out.Set(b.Min.X+x, b.Min.Y+y, img.At(median.X, median.Y))
+		}
+	}
+
+	return out
+}
+
+// Reads grid[y][x], clamping coordinates that fall outside the grid to its
+// nearest edge.
+func clampedAt(grid [][]float64, x, y int) float64 {
+	y = max(0, min(len(grid)-1, y))
+	x = max(0, min(len(grid[y])-1, x))
+	return grid[y][x]
+}
+
+// Separable gaussian blur with a kernel of 2*radius+1 taps.
+func gaussianBlur(grid [][]float64, radius int) [][]float64 {
+	if radius < 1 {
+		return grid
+	}
+
+	sigma := float64(radius) / 2
+	kernel := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	h := len(grid)
+	horizontal := make([][]float64, h)
+	for y := range h {
+		horizontal[y] = make([]float64, len(grid[y]))
+		for x := range grid[y] {
+			for i, k := range kernel {
+				horizontal[y][x] += k * clampedAt(grid, x+i-radius, y)
+			}
+		}
+	}
+
+	blurred := make([][]float64, h)
+	for y := range h {
+		blurred[y] = make([]float64, len(grid[y]))
+		for x := range grid[y] {
+			for i, k := range kernel {
+				blurred[y][x] += k * clampedAt(horizontal, x, y+i-radius)
+			}
+		}
+	}
+
+	return blurred
+}
+
+// Grows the white regions of a mask by radius pixels in every direction,
+// using a square structuring element.
+func DilateMask(mask image.Image, radius int) image.Image {
+	return morphMask(mask, radius, true)
+}
+
+// Shrinks the white regions of a mask by radius pixels in every direction,
+// using a square structuring element.
+func ErodeMask(mask image.Image, radius int) image.Image {
+	return morphMask(mask, radius, false)
+}
+
+// A pixel ends up the color of grow, white or black, if any pixel in the
+// square around it is. The square is separable, so rows and then columns are
+// scanned. Pixels past the edges are ignored.
+func morphMask(mask image.Image, radius int, grow bool) image.Image {
+	if radius < 1 {
+		return mask
+	}
+
+	b := mask.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := make([][]bool, h)
+	for y := range h {
+		src[y] = make([]bool, w)
+		for x := range w {
+			src[y][x] = (mask.At(b.Min.X+x, b.Min.Y+y) == RGBAWhite) == grow
+		}
+	}
+
+	rows := make([][]bool, h)
+	for y := range h {
+		rows[y] = make([]bool, w)
+		for x := range w {
+			for i := max(0, x-radius); i <= min(w-1, x+radius) && !rows[y][x]; i++ {
+				rows[y][x] = src[y][i]
+			}
+		}
+	}
+
+	out := image.NewRGBA(b)
+	for y := range h {
+		for x := range w {
+			hit := false
+			for j := max(0, y-radius); j <= min(h-1, y+radius) && !hit; j++ {
+				hit = rows[j][x]
+			}
+			if hit == grow {
+				out.Set(b.Min.X+x, b.Min.Y+y, RGBAWhite)
+			} else {
+				out.Set(b.Min.X+x, b.Min.Y+y, RGBABlack)
+			}
+		}
+	}
+
+	return out
+}
+
+// Softens the boundaries of a mask by blurring it, clamping at the edges, and
+// thresholding the result back to black and white at half intensity.
+func BlurMask(mask image.Image, radius float64) image.Image {
+	if radius <= 0 {
+		return mask
+	}
+
+	b := mask.Bounds()
+	grid := make([][]float64, b.Dy())
+	for y := range grid {
+		grid[y] = make([]float64, b.Dx())
+		for x := range grid[y] {
+			if mask.At(b.Min.X+x, b.Min.Y+y) == RGBAWhite {
+				grid[y][x] = 1
+			}
+		}
+	}
+	grid = gaussianBlur(grid, int(math.Ceil(radius)))
+
+	out := image.NewRGBA(b)
+	for y := range grid {
+		for x, v := range grid[y] {
+			if v > 0.5 {
+				out.Set(b.Min.X+x, b.Min.Y+y, RGBAWhite)
+			} else {
+				out.Set(b.Min.X+x, b.Min.Y+y, RGBABlack)
+			}
+		}
+	}
+
+	return out
+}
+
+// Marks pixels whose hue lies in [loHue, hiHue] degrees. When loHue is greater
+// than hiHue the range wraps around through 0, so 330 to 30 selects reds.
+func generateHueMask(original image.Image, loHue, hiHue float64, invert bool) (image.Image, error) {
+	if loHue < 0 || loHue > 360 || hiHue < 0 || hiHue > 360 {
+		return nil, errors.New("Hue thresholds must be between 0 and 360.")
+	}
+
+	b := original.Bounds()
+	mask := image.NewRGBA(b)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			hue := getHue(original.At(x, y))
+			var inside bool
+			if loHue <= hiHue {
+				inside = hue >= loHue && hue <= hiHue
+			} else {
+				inside = hue >= loHue || hue <= hiHue
+			}
+			if inside != invert {
+				mask.Set(x, y, RGBAWhite)
+			} else {
+				mask.Set(x, y, RGBABlack)
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+// Marks pixels whose gradient value in [0, 1] lies in [lo, hi].
+func thresholdGradient(b image.Rectangle, lo, hi float64, invert bool, value func(x, y int) float64) (image.Image, error) {
+	if lo > hi {
+		return nil, errors.New("Low gradient threshold must be less than high gradient threshold.")
+	}
+	if lo < 0 || hi > 1 {
+		return nil, errors.New("Gradient thresholds must be between 0 and 1.")
+	}
+
+	mask := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			v := value(x, y)
+			if (v >= lo && v <= hi) != invert {
+				mask.Set(x, y, RGBAWhite)
+			} else {
+				mask.Set(x, y, RGBABlack)
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+// Marks pixels whose projection onto the axis at angle degrees clockwise from
+// horizontal, normalized to [0, 1] across the image, lies in [lo, hi].
+func generateLinearGradientMask(img image.Image, angle float64, lo, hi float64, invert bool) (image.Image, error) {
+	b := img.Bounds()
+	rad := angle * math.Pi / 180
+	dx, dy := math.Cos(rad), math.Sin(rad)
+
+	project := func(x, y int) float64 {
+		return float64(x)*dx + float64(y)*dy
+	}
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	for _, p := range []image.Point{b.Min, {b.Max.X - 1, b.Min.Y}, {b.Min.X, b.Max.Y - 1}, b.Max.Sub(image.Pt(1, 1))} {
+		lowest = min(lowest, project(p.X, p.Y))
+		highest = max(highest, project(p.X, p.Y))
+	}
+	span := max(highest-lowest, 1)
+
+	return thresholdGradient(b, lo, hi, invert, func(x, y int) float64 {
+		return (project(x, y) - lowest) / span
+	})
+}
+
+// Marks pixels whose distance from the image center, normalized to [0, 1] at
+// the corners, lies in [lo, hi].
+func generateRadialGradientMask(img image.Image, lo, hi float64, invert bool) (image.Image, error) {
+	b := img.Bounds()
+	cx := float64(b.Min.X+b.Max.X-1) / 2
+	cy := float64(b.Min.Y+b.Max.Y-1) / 2
+	radius := max(math.Hypot(cx-float64(b.Min.X), cy-float64(b.Min.Y)), 1)
+
+	return thresholdGradient(b, lo, hi, invert, func(x, y int) float64 {
+		return math.Hypot(float64(x)-cx, float64(y)-cy) / radius
+	})
+}
+
+// Marks pixels whose HSV saturation lies in [loSat, hiSat].
+func generateSaturationMask(original image.Image, loSat, hiSat float64, invert bool) (image.Image, error) {
+	if loSat > hiSat {
+		return nil, errors.New("Low saturation must be less than high saturation.")
+	}
+	if loSat < 0 || hiSat > 1 {
+		return nil, errors.New("Saturation thresholds must be between 0 and 1.")
+	}
+
+	b := original.Bounds()
+	mask := image.NewRGBA(b)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			s := getSaturation(original.At(x, y))
+			if (s >= loSat && s <= hiSat) != invert {
+				mask.Set(x, y, RGBAWhite)
+			} else {
+				mask.Set(x, y, RGBABlack)
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+// https://en.wikipedia.org/wiki/Sobel_operator
+func sobel(grid [][]float64) ([][]float64, [][]float64) {
+	h := len(grid)
+	gx := make([][]float64, h)
+	gy := make([][]float64, h)
+
+	for y := range h {
+		gx[y] = make([]float64, len(grid[y]))
+		gy[y] = make([]float64, len(grid[y]))
+		for x := range grid[y] {
+			tl, t, tr := clampedAt(grid, x-1, y-1), clampedAt(grid, x, y-1), clampedAt(grid, x+1, y-1)
+			l, r := clampedAt(grid, x-1, y), clampedAt(grid, x+1, y)
+			bl, b, br := clampedAt(grid, x-1, y+1), clampedAt(grid, x, y+1), clampedAt(grid, x+1, y+1)
+			gx[y][x] = (tr + 2*r + br) - (tl + 2*l + bl)
+			gy[y][x] = (bl + 2*b + br) - (tl + 2*t + tr)
+		}
+	}
+
+	return gx, gy
+}
+
+// https://en.wikipedia.org/wiki/Sobel_operator
+// Marks pixels whose gradient magnitude, measured on luminance normalized to
+// [0, 1], is above threshold.
+func generateEdgeMask(original image.Image, threshold float64, invert bool) (image.Image, error) {
+	if threshold < 0 {
+		return nil, errors.New("Edge threshold must be positive.")
+	}
+
+	b := original.Bounds()
+	mask := image.NewRGBA(b)
+	luminance := luminanceMap(original)
+	for y := range luminance {
+		for x := range luminance[y] {
+			luminance[y][x] /= 65535
+		}
+	}
+	gx, gy := sobel(luminance)
+
+	for y := range gx {
+		for x := range gx[y] {
+			edge := math.Hypot(gx[y][x], gy[y][x]) > threshold
+			if edge != invert {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBAWhite)
+			} else {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBABlack)
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+// https://en.wikipedia.org/wiki/Canny_edge_detector
+// Thresholds apply to the gradient of luminance normalized to [0, 1].
+func generateCannyMask(original image.Image, lo float64, hi float64, blurRadius int) (image.Image, error) {
+	if lo > hi {
+		return nil, errors.New("Low threshold must be less than high threshold.")
+	}
+	if lo < 0 || hi < 0 {
+		return nil, errors.New("Threshold values must be positive.")
+	}
+
+	luminance := luminanceMap(original)
+	for y := range luminance {
+		for x := range luminance[y] {
+			luminance[y][x] /= 65535
+		}
+	}
+	gx, gy := sobel(gaussianBlur(luminance, blurRadius))
+
+	h := len(gx)
+	magnitude := make([][]float64, h)
+	for y := range h {
+		magnitude[y] = make([]float64, len(gx[y]))
+		for x := range gx[y] {
+			magnitude[y][x] = math.Hypot(gx[y][x], gy[y][x])
+		}
+	}
+
+	// Non-maximum suppression thins edges to the local maxima across the
+	// gradient direction, then double thresholding marks strong and weak
+	// edge pixels.
+	const weak, strong = 1, 2
+	edges := make([][]int, h)
+	var stack []image.Point
+	for y := range h {
+		edges[y] = make([]int, len(magnitude[y]))
+		for x := range magnitude[y] {
+			m := magnitude[y][x]
+			if m < lo || m == 0 {
+				continue
+			}
+
+			angle := math.Mod(math.Atan2(gy[y][x], gx[y][x])*180/math.Pi+180, 180)
+			var dx, dy int
+			switch {
+			case angle < 22.5 || angle >= 157.5:
+				dx, dy = 1, 0
+			case angle < 67.5:
+				dx, dy = 1, 1
+			case angle < 112.5:
+				dx, dy = 0, 1
+			default:
+				dx, dy = -1, 1
+			}
+			if m < clampedAt(magnitude, x+dx, y+dy) || m < clampedAt(magnitude, x-dx, y-dy) {
+				continue
+			}
+
+			if m >= hi {
+				edges[y][x] = strong
+				stack = append(stack, image.Pt(x, y))
+			} else {
+				edges[y][x] = weak
+			}
+		}
+	}
+
+	// Hysteresis keeps weak edges only when they connect to a strong edge.
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for ny := max(0, p.Y-1); ny <= min(h-1, p.Y+1); ny++ {
+			for nx := max(0, p.X-1); nx <= min(len(edges[ny])-1, p.X+1); nx++ {
+				if edges[ny][nx] == weak {
+					edges[ny][nx] = strong
+					stack = append(stack, image.Pt(nx, ny))
+				}
+			}
+		}
+	}
+
+	b := original.Bounds()
+	mask := image.NewRGBA(b)
+	for y := range h {
+		for x := range edges[y] {
+			if edges[y][x] == strong {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBAWhite)
+			} else {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBABlack)
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+// Euclidean distance from every pixel to the nearest white pixel of the edge
+// mask, indexed as [y][x]. Uses the two pass 8SSEDT sweep which propagates
+// the offset to the nearest edge rather than just the distance.
+// https://github.com/Lisapple/8SSEDT
+func distanceTransform(edgeMask image.Image) [][]float64 {
+	b := edgeMask.Bounds()
+	w, h := b.Dx(), b.Dy()
+	const far = 1 << 20
+
+	offsets := make([][]image.Point, h)
+	for y := range h {
+		offsets[y] = make([]image.Point, w)
+		for x := range w {
+			if edgeMask.At(b.Min.X+x, b.Min.Y+y) != RGBAWhite {
+				offsets[y][x] = image.Pt(far, far)
+			}
+		}
+	}
+
+	lengthSq := func(p image.Point) int {
+		return p.X*p.X + p.Y*p.Y
+	}
+	compare := func(x, y, dx, dy int) {
+		nx, ny := x+dx, y+dy
+		if nx < 0 || ny < 0 || nx >= w || ny >= h {
+			return
+		}
+		candidate := offsets[ny][nx].Add(image.Pt(dx, dy))
+		if lengthSq(candidate) < lengthSq(offsets[y][x]) {
+			offsets[y][x] = candidate
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			compare(x, y, -1, 0)
+			compare(x, y, 0, -1)
+			compare(x, y, -1, -1)
+			compare(x, y, 1, -1)
+		}
+		for x := w - 1; x >= 0; x-- {
+			compare(x, y, 1, 0)
+		}
+	}
+	for y := h - 1; y >= 0; y-- {
+		for x := w - 1; x >= 0; x-- {
+			compare(x, y, 1, 0)
+			compare(x, y, 0, 1)
+			compare(x, y, -1, 1)
+			compare(x, y, 1, 1)
+		}
+		for x := 0; x < w; x++ {
+			compare(x, y, -1, 0)
+		}
+	}
+
+	distances := make([][]float64, h)
+	for y := range h {
+		distances[y] = make([]float64, w)
+		for x := range w {
+			distances[y][x] = math.Sqrt(float64(lengthSq(offsets[y][x])))
+		}
+	}
+
+	return distances
+}
+
+// Marks pixels whose distance to the nearest edge falls within [lo, hi].
+func generateSDFMask(edgeMask image.Image, lo int, hi int) image.Image {
+	b := edgeMask.Bounds()
+	mask := image.NewRGBA(b)
+	distances := distanceTransform(edgeMask)
+
+	for y := range distances {
+		for x, d := range distances[y] {
+			if d >= float64(lo) && d <= float64(hi) {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBAWhite)
+			} else {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBABlack)
+			}
+		}
+	}
+
+	return mask
+}
+
+type MaskOp int
+
+const (
+	MaskAnd MaskOp = iota
+	MaskOr
+	MaskXor
+	MaskSubtract
+)
+
+var maskOpNames = map[string]MaskOp{
+	"and":      MaskAnd,
+	"or":       MaskOr,
+	"xor":      MaskXor,
+	"subtract": MaskSubtract,
+}
+
+func (op *MaskOp) String() string {
+	for name, v := range maskOpNames {
+		if v == *op {
+			return name
+		}
+	}
+	return ""
+}
+
+func (op *MaskOp) Set(s string) error {
+	v, ok := maskOpNames[strings.ToLower(s)]
+	if !ok {
+		return fmt.Errorf("unknown mask operation: %s", s)
+	}
+	*op = v
+	return nil
+}
+
+// Combines two masks pixel by pixel, treating white as true and black as
+// false. Subtract keeps pixels white in a but not in b.
+func CombineMasks(a, b image.Image, op MaskOp) (image.Image, error) {
+	bounds := a.Bounds()
+	if b.Bounds() != bounds {
+		return nil, errors.New("Masks must have the same dimensions to be combined.")
+	}
+	out := image.NewRGBA(bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p, q := a.At(x, y) == RGBAWhite, b.At(x, y) == RGBAWhite
+			var white bool
+			switch op {
+			case MaskAnd:
+				white = p && q
+			case MaskOr:
+				white = p || q
+			case MaskXor:
+				white = p != q
+			case MaskSubtract:
+				white = p && !q
+			default:
+				return nil, fmt.Errorf("unknown mask operation: %d", op)
+			}
+			if white {
+				out.Set(x, y, RGBAWhite)
+			} else {
+				out.Set(x, y, RGBABlack)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// Parses a polygon given as "x1,y1:x2,y2:...:xN,yN".
+func ParsePolygon(s string) ([]image.Point, error) {
+	var poly []image.Point
+	for _, vertex := range strings.Split(s, ":") {
+		var p image.Point
+		if _, err := fmt.Sscanf(vertex, "%d,%d", &p.X, &p.Y); err != nil {
+			return nil, fmt.Errorf("invalid polygon vertex: %s", vertex)
+		}
+		poly = append(poly, p)
+	}
+	if len(poly) < 3 {
+		return nil, errors.New("A polygon needs at least three vertices.")
+	}
+
+	return poly, nil
+}
+
+// https://en.wikipedia.org/wiki/Point_in_polygon#Ray_casting_algorithm
+// Pixels are tested at their centers, with the vertices relative to the top
+// left of b.
+func generatePolygonMask(b image.Rectangle, poly []image.Point) image.Image {
+	mask := image.NewRGBA(b)
+
+	for y := range b.Dy() {
+		py := float64(y) + 0.5
+		for x := range b.Dx() {
+			px := float64(x) + 0.5
+			inside := false
+			for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+				xi, yi := float64(poly[i].X), float64(poly[i].Y)
+				xj, yj := float64(poly[j].X), float64(poly[j].Y)
+				if (yi > py) != (yj > py) && px < (xj-xi)*(py-yi)/(yj-yi)+xi {
+					inside = !inside
+				}
+			}
+
+			if inside {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBAWhite)
+			} else {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBABlack)
+			}
+		}
+	}
+
+	return mask
+}
+
+// Marks pixels whose value, indexed as [y][x] from the top left of the
+// bounds, falls within [lo, hi].
+func thresholdMask(values [][]float64, b image.Rectangle, lo float64, hi float64) image.Image {
+	mask := image.NewRGBA(b)
+
+	for y := range values {
+		for x, v := range values[y] {
+			if v < lo || v > hi {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBABlack)
+			} else {
+				mask.Set(b.Min.X+x, b.Min.Y+y, RGBAWhite)
+			}
+		}
+	}
+
+	return mask
+}
+
+// Averages the luminance of each pixel along a line of the given length
+// through it, pointing angle degrees clockwise from the x axis.
+func motionBlurLuminance(img image.Image, angle, length int) [][]float64 {
+	luminance := luminanceMap(img)
+	if length < 2 {
+		return luminance
+	}
+
+	sin, cos := math.Sincos(float64(angle) * math.Pi / 180)
+	blurred := make([][]float64, len(luminance))
+	for y := range luminance {
+		blurred[y] = make([]float64, len(luminance[y]))
+		for x := range luminance[y] {
+			var sum float64
+			for i := range length {
+				t := float64(i) - float64(length-1)/2
+				sx := int(math.Round(float64(x) + t*cos))
+				sy := int(math.Round(float64(y) + t*sin))
+				sum += clampedAt(luminance, sx, sy)
+			}
+			blurred[y][x] = sum / float64(length)
+		}
+	}
+
+	return blurred
+}
+
+// Traces the contour lines of a height map at the given level with marching
+// squares, returning the segments found in each 2x2 cell of samples.
+// https://en.wikipedia.org/wiki/Marching_squares
+func marchingSquares(heights [][]float64, level float64) [][2]image.Point {
+	var segments [][2]image.Point
+
+	for y := 0; y < len(heights)-1; y++ {
+		for x := 0; x < len(heights[y])-1; x++ {
+			corners := [4]image.Point{{x, y}, {x + 1, y}, {x + 1, y + 1}, {x, y + 1}}
+
+			var crossings []image.Point
+			for i := range corners {
+				a, b := corners[i], corners[(i+1)%4]
+				ha, hb := heights[a.Y][a.X], heights[b.Y][b.X]
+				if (ha < level) == (hb < level) {
+					continue
+				}
+				t := (level - ha) / (hb - ha)
+				crossings = append(crossings, image.Pt(
+					int(math.Round(float64(a.X)+t*float64(b.X-a.X))),
+					int(math.Round(float64(a.Y)+t*float64(b.Y-a.Y))),
+				))
+			}
+
+			// Saddle cells cross all four edges and hold two segments.
+			for i := 0; i+1 < len(crossings); i += 2 {
+				segments = append(segments, [2]image.Point{crossings[i], crossings[i+1]})
+			}
+		}
+	}
+
+	return segments
+}
+
+// A topographic mask treating luminance as elevation. Contour lines are
+// traced at count levels evenly spaced within [lo, hi], and pixels within a
+// band of the given width around any contour are marked white.
+func generateContourBandMask(original image.Image, lo int, hi int, count int, width int) (image.Image, error) {
+	if lo > hi {
+		return nil, errors.New("Low threshold must be less than high threshold.")
+	}
+	if lo < 0 || hi < 0 {
+		return nil, errors.New("Threshold values must be positive.")
+	}
+	if count < 1 || width < 1 {
+		return nil, errors.New("Contour count and width must be positive.")
+	}
+
+	b := original.Bounds()
+	heights := luminanceMap(original)
+	contours := image.NewRGBA(b)
+	for k := range count {
+		level := float64(lo) + float64(k+1)*float64(hi-lo)/float64(count+1)
+		for _, segment := range marchingSquares(heights, level) {
+			for _, p := range bresenhamLine(segment[0], segment[1]) {
+				contours.Set(b.Min.X+p.X, b.Min.Y+p.Y, RGBAWhite)
+			}
+		}
+	}
+
+	return generateSDFMask(contours, 0, width/2), nil
+}
@@ -0,0 +1,73 @@
+package pixelsort
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func TestEdgeMask(t *testing.T) {
+	// White top half, black bottom half.
+	b := image.Rect(0, 0, 10, 10)
+	img := image.NewRGBA(b)
+	draw.Draw(img, b, image.NewUniform(color.Black), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 10, 5), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	luminance := luminanceMap(img)
+	gx, gy := sobel(luminance)
+	for y := range b.Dy() {
+		for x := range b.Dx() {
+			magnitude := math.Hypot(gx[y][x], gy[y][x])
+			border := y == 4 || y == 5
+			if border && magnitude == 0 {
+				t.Errorf("gradient at (%d, %d) is zero on the border", x, y)
+			}
+			if !border && magnitude != 0 {
+				t.Errorf("gradient at (%d, %d) = %v away from the border", x, y, magnitude)
+			}
+		}
+	}
+
+	mask, err := generateEdgeMask(img, 0.5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := range b.Dy() {
+		for x := range b.Dx() {
+			want := RGBABlack
+			if y == 4 || y == 5 {
+				want = RGBAWhite
+			}
+			if got := mask.At(x, y); got != want {
+				t.Errorf("mask at (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestDilateAndErodeMask(t *testing.T) {
+	b := image.Rect(0, 0, 7, 7)
+	mask := image.NewRGBA(b)
+	draw.Draw(mask, b, image.NewUniform(RGBABlack), image.Point{}, draw.Src)
+	mask.Set(3, 3, RGBAWhite)
+
+	dilated := DilateMask(mask, 1)
+	checkMask(t, "dilated", dilated, image.Rect(2, 2, 5, 5))
+	checkMask(t, "eroded", ErodeMask(dilated, 1), image.Rect(3, 3, 4, 4))
+}
+
+// Fails unless exactly the pixels of white are white in mask.
+func checkMask(t *testing.T, name string, mask image.Image, white image.Rectangle) {
+	t.Helper()
+	b := mask.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			want := image.Pt(x, y).In(white)
+			if got := mask.At(x, y) == RGBAWhite; got != want {
+				t.Errorf("%s mask at (%d, %d) white = %t, want %t", name, x, y, got, want)
+			}
+		}
+	}
+}
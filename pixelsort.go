@@ -1,32 +1,67 @@
-package main
+// Package pixelsort sorts runs of pixels selected by a mask.
+package pixelsort
 
 import (
+	"bytes"
+	"context"
 	"errors"
-	"flag"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"math"
+	"math/rand/v2"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
-	"rsc.io/getopt"
-
+	webpenc "github.com/chai2010/webp"
+	"golang.org/x/image/bmp"
 	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
 )
 
+// Decoders by file extension, tried when the contents of a file are not
+// recognized as any registered format.
+var extensionDecoders = map[string]func(io.Reader) (image.Image, error){
+	"bmp":  bmp.Decode,
+	"jpeg": jpeg.Decode,
+	"jpg":  jpeg.Decode,
+	"png":  png.Decode,
+	"tif":  tiff.Decode,
+	"tiff": tiff.Decode,
+	"webp": webp.Decode,
+}
+
 // https://reintech.io/blog/a-guide-to-gos-image-package-manipulating-and-processing-images
-func decodeImage(filename string) (image.Image, string, error) {
-	file, err := os.Open(filename)
+// A filename of "-" reads from standard input.
+func DecodeImage(filename string) (image.Image, string, error) {
+	var data []byte
+	var err error
+	if filename == "-" {
+		// Stdin is not seekable, so it is read in full before decoding.
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(filename)
+	}
 	if err != nil {
 		return nil, "", err
 	}
-	defer file.Close()
 
-	img, format, err := image.Decode(file)
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		format = strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+		if decode, ok := extensionDecoders[format]; ok {
+			img, err = decode(bytes.NewReader(data))
+		}
+	}
 	if err != nil {
 		return nil, "", err
 	}
@@ -34,9 +69,61 @@ func decodeImage(filename string) (image.Image, string, error) {
 	return img, format, nil
 }
 
+// Settings for the encoders of each output format. Zero values use the
+// defaults of the encoder.
+type EncodeOptions struct {
+	// In [1, 100].
+	JPEGQuality    int
+	PNGCompression png.CompressionLevel
+	// In [0, 100], ignored by lossless WebP.
+	WebPQuality     float32
+	WebPLossless    bool
+	TIFFCompression tiff.CompressionType
+}
+
+// PNG compression levels by name.
+var PNGCompressionLevels = map[string]png.CompressionLevel{
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"default": png.DefaultCompression,
+	"best":    png.BestCompression,
+}
+
+// TIFF compression types by name.
+var TIFFCompressionTypes = map[string]tiff.CompressionType{
+	"none":    tiff.Uncompressed,
+	"deflate": tiff.Deflate,
+}
+
+// TIFF compressions golang.org/x/image/tiff can read but not write.
+var UnwritableTIFFCompressions = []string{"lzw", "packbits"}
+
+// Opens a file for writing, creating its directory if needed. A filename of
+// "-" is standard output, which closing leaves open.
+func createOutput(filename string) (io.WriteCloser, error) {
+	if filename == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(filename)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
 // https://reintech.io/blog/a-guide-to-gos-image-package-manipulating-and-processing-images
-func encodeImage(filename string, img image.Image, format string) error {
-	file, err := os.Create(filename)
+// A filename of "-" writes to standard output.
+func EncodeImage(filename string, img image.Image, format string, opts EncodeOptions) error {
+	file, err := createOutput(filename)
 	if err != nil {
 		return err
 	}
@@ -44,18 +131,222 @@ func encodeImage(filename string, img image.Image, format string) error {
 
 	switch format {
 	case "jpeg", "jpg":
-		return jpeg.Encode(file, img, nil)
+		var jpegOpts *jpeg.Options
+		if opts.JPEGQuality != 0 {
+			jpegOpts = &jpeg.Options{Quality: opts.JPEGQuality}
+		}
+		return jpeg.Encode(file, img, jpegOpts)
 	case "png":
-		return png.Encode(file, img)
-	case "tiff":
-		return tiff.Encode(file, img, nil)
+		encoder := png.Encoder{CompressionLevel: opts.PNGCompression}
+		return encoder.Encode(file, img)
+	case "tiff", "tif":
+		return tiff.Encode(file, img, &tiff.Options{Compression: opts.TIFFCompression})
+	case "bmp":
+		return bmp.Encode(file, img)
+	case "webp":
+		webpOpts := &webpenc.Options{Lossless: opts.WebPLossless, Quality: opts.WebPQuality}
+		if webpOpts.Quality == 0 {
+			webpOpts.Quality = webpenc.DefaulQuality
+		}
+		err = webpenc.Encode(file, img, webpOpts)
+		if err != nil {
+			return fmt.Errorf("could not encode webp: %w", err)
+		}
+		return nil
 	default:
 		return fmt.Errorf("unsupported format: %s", format)
 	}
 }
 
-const lowThreshold int = 10000
-const highThreshold int = 30000
+// The absolute difference in perceived luminance between two images of the
+// same size, scaled to [0, 255], as an *image.Gray. Brighter pixels changed
+// more.
+func DiffImages(a, b image.Image) image.Image {
+	ab, bb := a.Bounds(), b.Bounds()
+	out := image.NewGray(image.Rect(0, 0, min(ab.Dx(), bb.Dx()), min(ab.Dy(), bb.Dy())))
+
+	for y := range out.Bounds().Dy() {
+		for x := range out.Bounds().Dx() {
+			la := getPerceivedLuminance(a.At(ab.Min.X+x, ab.Min.Y+y))
+			lb := getPerceivedLuminance(b.At(bb.Min.X+x, bb.Min.Y+y))
+			out.SetGray(x, y, color.Gray{uint8(math.Round(math.Abs(la-lb) / 257))})
+		}
+	}
+
+	return out
+}
+
+// Inverts the colors of every pixel, keeping its alpha. Colors are inverted
+// in 8 bits without premultiplied alpha.
+func InvertImage(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			out.Set(x, y, color.NRGBA{255 - c.R, 255 - c.G, 255 - c.B, c.A})
+		}
+	}
+
+	return out
+}
+
+// Shifts the hue of every pixel by degrees around the color wheel, keeping
+// its saturation and value. A shift of 180 degrees gives the complementary
+// colors.
+func RotateHue(img image.Image, degrees float64) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.At(x, y)
+			out.Set(x, y, withHue(c, getHue(c)+degrees))
+		}
+	}
+
+	return out
+}
+
+// Remaps the red, green, and blue channels of every pixel, so an order of
+// "BGR" puts the blue channel in red and the red channel in blue. The order
+// must be a permutation of "RGB".
+func SwapChannels(img image.Image, order string) (image.Image, error) {
+	order = strings.ToUpper(order)
+	if len(order) != 3 || !strings.ContainsRune(order, 'R') || !strings.ContainsRune(order, 'G') || !strings.ContainsRune(order, 'B') {
+		return nil, fmt.Errorf("invalid channel order: %s", order)
+	}
+	var source [3]int
+	for i, c := range order {
+		source[i] = strings.IndexRune("RGB", c)
+	}
+
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			channels := [3]uint32{r, g, bl}
+			out.SetRGBA(x, y, color.RGBA{
+				uint8(channels[source[0]] >> 8),
+				uint8(channels[source[1]] >> 8),
+				uint8(channels[source[2]] >> 8),
+				uint8(a >> 8),
+			})
+		}
+	}
+
+	return out, nil
+}
+
+// Mixes sorted over original with the given opacity, interpolating each
+// channel in linear light so midtones are not darkened.
+func BlendImages(original, sorted image.Image, alpha float64) image.Image {
+	if alpha >= 1 {
+		return sorted
+	}
+
+	b := original.Bounds()
+	out := image.NewNRGBA64(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			o := color.NRGBA64Model.Convert(original.At(x, y)).(color.NRGBA64)
+			s := color.NRGBA64Model.Convert(sorted.At(x, y)).(color.NRGBA64)
+			mix := func(a, b uint16) uint16 {
+				return delinearize(linearize(uint32(a))*(1-alpha) + linearize(uint32(b))*alpha)
+			}
+			out.SetNRGBA64(x, y, color.NRGBA64{
+				mix(o.R, s.R),
+				mix(o.G, s.G),
+				mix(o.B, s.B),
+				uint16(math.Round(float64(o.A)*(1-alpha) + float64(s.A)*alpha)),
+			})
+		}
+	}
+
+	return out
+}
+
+// Cuts an image into n equal parts side by side when axis is horizontal or
+// stacked when axis is vertical. The last part absorbs any remainder.
+func splitImageParts(img image.Image, axis string, n int) ([]image.Image, error) {
+	b := img.Bounds()
+	parts := make([]image.Image, n)
+	for i := range n {
+		r := b
+		switch axis {
+		case "horizontal":
+			r.Min.X = b.Min.X + i*b.Dx()/n
+			r.Max.X = b.Min.X + (i+1)*b.Dx()/n
+		case "vertical":
+			r.Min.Y = b.Min.Y + i*b.Dy()/n
+			r.Max.Y = b.Min.Y + (i+1)*b.Dy()/n
+		default:
+			return nil, fmt.Errorf("unknown split axis: %s", axis)
+		}
+		parts[i] = cropImage(img, r)
+	}
+
+	return parts, nil
+}
+
+// Cuts an image into halves, left and right when axis is horizontal or top
+// and bottom when axis is vertical.
+func SplitImage(img image.Image, axis string) (image.Image, image.Image, error) {
+	parts, err := splitImageParts(img, axis, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parts[0], parts[1], nil
+}
+
+// Cuts an image into thirds along axis like SplitImage.
+func SplitImageThirds(img image.Image, axis string) (image.Image, image.Image, image.Image, error) {
+	parts, err := splitImageParts(img, axis, 3)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// Parses a rectangle written as "x,y,w,h".
+func ParseRegion(s string) (image.Rectangle, error) {
+	var x, y, w, h int
+	if _, err := fmt.Sscanf(s, "%d,%d,%d,%d", &x, &y, &w, &h); err != nil {
+		return image.Rectangle{}, fmt.Errorf("invalid region: %s", s)
+	}
+	if w < 1 || h < 1 {
+		return image.Rectangle{}, errors.New("Region dimensions must be positive.")
+	}
+
+	return image.Rect(x, y, x+w, y+h), nil
+}
+
+// Parses a color written as rrggbb, with or without a leading #.
+func ParseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// How many rows, columns, or spans are processed between checks for
+// cancellation.
+const cancelCheckInterval = 100
+
+// Luminance thresholds are on the 16-bit scale of getPerceivedLuminance, 0
+// for black and 65535 for white, whatever the bit depth of the image. An
+// 8-bit value v is v * ThresholdScale on this scale, so that 255 becomes 65535.
+const ThresholdScale int = 257
+
+// The defaults, 39 and 117 on the 8-bit scale.
+const LowThreshold int = 39 * ThresholdScale
+const HighThreshold int = 117 * ThresholdScale
 
 // https://www.itu.int/rec/R-REC-BT.601
 const perceivedR float64 = 0.299
@@ -67,7 +358,51 @@ var RGBABlack color.RGBA = color.RGBA{0, 0, 0, 255}
 var RGBAGreen color.RGBA = color.RGBA{0, 255, 0, 255}
 var RGBAMagenta color.RGBA = color.RGBA{255, 0, 255, 255}
 
-func generateLuminanceMask(original image.Image, lo int, hi int, invert bool) (image.Image, error) {
+// Perceived luminance in [0, 65535]. color.Color.RGBA scales the channels of
+// every image type to 16 bits, so 8-bit images such as *image.RGBA and 16-bit
+// images such as *image.RGBA64, *image.NRGBA64, and *image.Gray16 share this
+// scale and are compared against the same thresholds without conversion.
+func getPerceivedLuminance(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return math.Sqrt(perceivedR*math.Pow(float64(r), 2) + perceivedG*math.Pow(float64(g), 2) + perceivedB*math.Pow(float64(b), 2))
+}
+
+// Counts the pixels of each perceived luminance, rounded down.
+func computeLuminanceHistogram(img image.Image) [65536]int {
+	var hist [65536]int
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			hist[min(int(getPerceivedLuminance(img.At(x, y))), len(hist)-1)]++
+		}
+	}
+	return hist
+}
+
+// The luminances below which the fractions lo and hi of the pixels fall.
+func percentilesFromHistogram(hist [65536]int, lo, hi float64) (int, int) {
+	var total int
+	for _, n := range hist {
+		total += n
+	}
+
+	loValue, hiValue := -1, -1
+	var seen int
+	for v, n := range hist {
+		seen += n
+		if loValue < 0 && float64(seen) >= lo*float64(total) {
+			loValue = v
+		}
+		if hiValue < 0 && float64(seen) >= hi*float64(total) {
+			hiValue = v
+			break
+		}
+	}
+
+	return max(loValue, 0), max(hiValue, 0)
+}
+
+func GenerateLuminanceMask(original image.Image, lo int, hi int, invert bool) (image.Image, error) {
 	if lo > hi {
 		return nil, errors.New("Low threshold must be less than high threshold.")
 	}
@@ -77,10 +412,10 @@ func generateLuminanceMask(original image.Image, lo int, hi int, invert bool) (i
 
 	mask := image.NewRGBA(original.Bounds())
 
-	for y := range original.Bounds().Max.Y {
-		for x := range original.Bounds().Max.X {
-			r, g, b, _ := original.At(x, y).RGBA()
-			perceivedLuminance := math.Sqrt(perceivedR*math.Pow(float64(r), 2) + perceivedG*math.Pow(float64(g), 2) + perceivedB*math.Pow(float64(b), 2))
+	b := original.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			perceivedLuminance := getPerceivedLuminance(original.At(x, y))
 			if perceivedLuminance < float64(lo) || perceivedLuminance > float64(hi) {
 				if !invert {
 					mask.Set(x, y, RGBABlack)
@@ -104,12 +439,19 @@ type Span struct {
 	id  int
 	idx int
 	len int
+	// Whether the pixels of the span are read and written from its end.
+	reversed bool
 }
 
 type ColorSpan struct {
 	pixels []color.Color
 	id     int
 	idx    int
+	// Where each pixel was read from in the source image.
+	points []image.Point
+	// Precomputed sort keys for each pixel, used by SortSpans when present.
+	keys     []float64
+	reversed bool
 }
 
 type SpanType int
@@ -118,77 +460,230 @@ const (
 	Horizontal SpanType = iota
 	Vertical
 	Diagonal
+	Radial
+	Spiral
+	Fan
+	TextureAware
+	Ellipse
+	Grid
+	BresenhamLine
+	WatershedBoundary
+	Polygon
+	Diamond
+	Geodesic
+	QuadTree
+	TextLine
+	Flow
 )
 
-func generateHorizontalSpans(mask image.Image, minSpanLen int) []Span {
+var spanTypeNames = map[string]SpanType{
+	"horizontal":         Horizontal,
+	"vertical":           Vertical,
+	"diagonal":           Diagonal,
+	"radial":             Radial,
+	"spiral":             Spiral,
+	"fan":                Fan,
+	"texture-aware":      TextureAware,
+	"ellipse":            Ellipse,
+	"grid":               Grid,
+	"bresenham-line":     BresenhamLine,
+	"watershed-boundary": WatershedBoundary,
+	"polygon":            Polygon,
+	"diamond":            Diamond,
+	"geodesic":           Geodesic,
+	"quad-tree":          QuadTree,
+	"text-line":          TextLine,
+	"flow":               Flow,
+}
+
+func (t *SpanType) String() string {
+	for name, v := range spanTypeNames {
+		if v == *t {
+			return name
+		}
+	}
+	return strconv.Itoa(int(*t))
+}
+
+// Span types can be selected by name or by their numeric value.
+func (t *SpanType) Set(s string) error {
+	if v, ok := spanTypeNames[s]; ok {
+		*t = v
+		return nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n >= len(spanTypeNames) {
+		return fmt.Errorf("unknown span type: %s", s)
+	}
+	*t = SpanType(n)
+	return nil
+}
+
+// With zigzag, spans on odd rows run right to left.
+func GenerateHorizontalSpans(ctx context.Context, mask image.Image, minSpanLen, maxSpanLen int, zigzag bool) ([]Span, error) {
+	err := validateSpanLengths(minSpanLen, maxSpanLen)
+	if err != nil {
+		return nil, err
+	}
+
 	var spans []Span = make([]Span, 0)
+	b := mask.Bounds()
 
-	for y := range mask.Bounds().Dy() {
-		var currentColor = mask.At(0, y)
+	for y := range b.Dy() {
+		if y%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var currentColor = mask.At(b.Min.X, b.Min.Y+y)
 		var keep bool = currentColor == RGBAWhite
-		var span Span = Span{y, 0, 0}
+		var reversed bool = zigzag && y%2 == 1
+		var span Span = Span{y, 0, 0, reversed}
 
-		for x := range mask.Bounds().Dx() {
-			if mask.At(x, y) == currentColor {
+		for x := range b.Dx() {
+			if mask.At(b.Min.X+x, b.Min.Y+y) == currentColor {
 				span.len++
 			} else {
 				if keep && span.len >= minSpanLen {
 					spans = append(spans, span)
 				}
-				currentColor = mask.At(x, y)
-				span = Span{y, x, 0}
+				currentColor = mask.At(b.Min.X+x, b.Min.Y+y)
+				span = Span{y, x, 0, reversed}
 				keep = !keep
 			}
 
-			if x == mask.Bounds().Dx()-1 && keep {
+			if x == b.Dx()-1 && keep {
 				spans = append(spans, span)
 			}
 		}
 	}
 
-	return spans
+	return splitLongSpans(spans, maxSpanLen), nil
 }
 
-func generateVerticalSpans(mask image.Image, minSpanLen int) []Span {
+func GenerateVerticalSpans(ctx context.Context, mask image.Image, minSpanLen, maxSpanLen int) ([]Span, error) {
+	err := validateSpanLengths(minSpanLen, maxSpanLen)
+	if err != nil {
+		return nil, err
+	}
+
 	var spans []Span = make([]Span, 0)
+	b := mask.Bounds()
 
-	for x := range mask.Bounds().Dx() {
-		var currentColor = mask.At(x, 0)
+	for x := range b.Dx() {
+		if x%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var currentColor = mask.At(b.Min.X+x, b.Min.Y)
 		var keep bool = currentColor == RGBAWhite
-		var span Span = Span{x, 0, 0}
+		var span Span = Span{x, 0, 0, false}
 
-		for y := range mask.Bounds().Dy() {
-			if mask.At(x, y) == currentColor {
+		for y := range b.Dy() {
+			if mask.At(b.Min.X+x, b.Min.Y+y) == currentColor {
 				span.len++
 			} else {
 				if keep && span.len >= minSpanLen {
 					spans = append(spans, span)
 				}
-				currentColor = mask.At(x, y)
-				span = Span{x, y, 0}
+				currentColor = mask.At(b.Min.X+x, b.Min.Y+y)
+				span = Span{x, y, 0, false}
 				keep = !keep
 			}
 
-			if y == mask.Bounds().Dy()-1 && keep {
+			if y == b.Dy()-1 && keep {
 				spans = append(spans, span)
 			}
 		}
 	}
 
-	return spans
+	return splitLongSpans(spans, maxSpanLen), nil
+}
+
+func validateSpanLengths(minSpanLen, maxSpanLen int) error {
+	if minSpanLen < 1 {
+		return errors.New("Minimum span length must be at least 1.")
+	}
+	if maxSpanLen < 0 {
+		return errors.New("Maximum span length must not be negative.")
+	}
+	return nil
+}
+
+// Breaks spans longer than maxSpanLen into consecutive pieces no longer than
+// maxSpanLen. A maxSpanLen of 0 leaves spans whole.
+func splitLongSpans(spans []Span, maxSpanLen int) []Span {
+	if maxSpanLen <= 0 {
+		return spans
+	}
+
+	var split []Span = make([]Span, 0, len(spans))
+	for _, span := range spans {
+		for span.len > maxSpanLen {
+			split = append(split, Span{span.id, span.idx, maxSpanLen, span.reversed})
+			span.idx += maxSpanLen
+			span.len -= maxSpanLen
+		}
+		split = append(split, span)
+	}
+
+	return split
+}
+
+// The pixel a diagonal starts from. The first h diagonals start on the left
+// edge from the bottom up, the rest on the top edge from left to right.
+func diagonalStart(id, h int) image.Point {
+	if id < h {
+		return image.Pt(0, h-1-id)
+	}
+	return image.Pt(id-h+1, 0)
+}
+
+// Spans along lines running down and to the right at 45 degrees. Each span's
+// id is the diagonal it lies on and idx is its distance along the diagonal.
+func GenerateDiagonalSpans(ctx context.Context, mask image.Image, minSpanLen int) ([]Span, error) {
+	var spans []Span = make([]Span, 0)
+	b := mask.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	for id := range w + h - 1 {
+		if id%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		start := b.Min.Add(diagonalStart(id, h))
+		length := min(b.Max.X-start.X, b.Max.Y-start.Y)
+		var span Span = Span{id, 0, 0, false}
+
+		for i := range length {
+			if mask.At(start.X+i, start.Y+i) == RGBAWhite {
+				if span.len == 0 {
+					span.idx = i
+				}
+				span.len++
+				continue
+			}
+			if span.len >= minSpanLen && span.len > 0 {
+				spans = append(spans, span)
+			}
+			span.len = 0
+		}
+		if span.len >= minSpanLen && span.len > 0 {
+			spans = append(spans, span)
+		}
+	}
+
+	return spans, nil
 }
 
 func debugHorizontalSpans(mask image.Image, spans []Span) {
 	b := mask.Bounds()
-	img := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	img := image.NewRGBA(b)
 
 	for _, span := range spans {
 		for i := range span.len {
-			img.Set(span.idx+i, span.id, RGBAGreen)
+			img.Set(b.Min.X+span.idx+i, b.Min.Y+span.id, RGBAGreen)
 		}
 	}
 
-	err := encodeImage(fmt.Sprintf("./output/spanDBG.png"), img, "png")
+	err := EncodeImage(fmt.Sprintf("./output/spanDBG.png"), img, "png", EncodeOptions{})
 	if err != nil {
 		panic(err.Error())
 	}
@@ -196,15 +691,15 @@ func debugHorizontalSpans(mask image.Image, spans []Span) {
 
 func debugVerticalSpans(mask image.Image, spans []Span) {
 	b := mask.Bounds()
-	img := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	img := image.NewRGBA(b)
 
 	for _, span := range spans {
 		for i := range span.len {
-			img.Set(span.id, span.idx+i, RGBAGreen)
+			img.Set(b.Min.X+span.id, b.Min.Y+span.idx+i, RGBAGreen)
 		}
 	}
 
-	err := encodeImage(fmt.Sprintf("./output/spanDBG.png"), img, "png")
+	err := EncodeImage(fmt.Sprintf("./output/spanDBG.png"), img, "png", EncodeOptions{})
 	if err != nil {
 		panic(err.Error())
 	}
@@ -240,157 +735,933 @@ func getHue(c color.Color) float64 {
 	return math.Round(hue)
 }
 
-func generateHorizontalColorSpans(img image.Image, spans []Span) []ColorSpan {
-	var cspans []ColorSpan = make([]ColorSpan, len(spans))
+func GenerateHorizontalColorSpans(img image.Image, spans []Span) []ColorSpan {
+	var cspans []ColorSpan = make([]ColorSpan, 0, len(spans))
+
+	b := img.Bounds()
 
 	for _, span := range spans {
 		c := make([]color.Color, span.len)
+		p := make([]image.Point, span.len)
 		for i := range span.len {
-			c[i] = img.At(span.idx+i, span.id)
+			x := span.idx + i
+			if span.reversed {
+				x = span.idx + span.len - 1 - i
+			}
+			p[i] = b.Min.Add(image.Pt(x, span.id))
+			c[i] = img.At(p[i].X, p[i].Y)
 		}
-		cspans = append(cspans, ColorSpan{c, span.id, span.idx})
+		cspans = append(cspans, ColorSpan{c, span.id, span.idx, p, nil, span.reversed})
 	}
 
 	return cspans
 }
 
-func generateVerticalColorSpans(img image.Image, spans []Span) []ColorSpan {
-	var cspans []ColorSpan = make([]ColorSpan, len(spans))
+func GenerateVerticalColorSpans(img image.Image, spans []Span) []ColorSpan {
+	var cspans []ColorSpan = make([]ColorSpan, 0, len(spans))
+
+	b := img.Bounds()
 
 	for _, span := range spans {
 		c := make([]color.Color, span.len)
+		p := make([]image.Point, span.len)
 		for i := range span.len {
-			c[i] = img.At(span.id, span.idx+i)
+			p[i] = b.Min.Add(image.Pt(span.id, span.idx+i))
+			c[i] = img.At(p[i].X, p[i].Y)
 		}
-		cspans = append(cspans, ColorSpan{c, span.id, span.idx})
+		cspans = append(cspans, ColorSpan{c, span.id, span.idx, p, nil, false})
 	}
 
 	return cspans
 }
 
-func sortSpans(spans []ColorSpan, reverse bool) []ColorSpan {
-	var sortedSpans []ColorSpan = make([]ColorSpan, 0)
+func GenerateDiagonalColorSpans(img image.Image, spans []Span) []ColorSpan {
+	var cspans []ColorSpan = make([]ColorSpan, 0, len(spans))
+	b := img.Bounds()
+
 	for _, span := range spans {
-		if len(span.pixels) > 1 {
-			sort.Slice(span.pixels, func(i, j int) bool {
-				a := getHue(span.pixels[i])
-				b := getHue(span.pixels[j])
-				if !reverse {
-					return a > b
-				} else {
-					return a < b
+		start := b.Min.Add(diagonalStart(span.id, b.Dy()))
+		c := make([]color.Color, span.len)
+		p := make([]image.Point, span.len)
+		for i := range span.len {
+			p[i] = start.Add(image.Pt(span.idx+i, span.idx+i))
+			c[i] = img.At(p[i].X, p[i].Y)
+		}
+		cspans = append(cspans, ColorSpan{c, span.id, span.idx, p, nil, false})
+	}
+
+	return cspans
+}
+
+// Sorts the pixels of a span by precomputed keys, keeping each pixel's
+// source point alongside it.
+type spanSorter struct {
+	span    ColorSpan
+	keys    []float64
+	reverse bool
+}
+
+func (s spanSorter) Len() int {
+	return len(s.keys)
+}
+
+func (s spanSorter) Less(i, j int) bool {
+	if !s.reverse {
+		return s.keys[i] > s.keys[j]
+	} else {
+		return s.keys[i] < s.keys[j]
+	}
+}
+
+func (s spanSorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.span.pixels[i], s.span.pixels[j] = s.span.pixels[j], s.span.pixels[i]
+	s.span.points[i], s.span.points[j] = s.span.points[j], s.span.points[i]
+}
+
+// Sorts spans on a pool of workers goroutines, keeping the order of the
+// spans. Spans too short to sort are dropped.
+func SortSpans(ctx context.Context, spans []ColorSpan, key SortKeyFunc, reverse bool, workers int) ([]ColorSpan, error) {
+	return sortSpans(ctx, spans, key, reverse, workers, nil)
+}
+
+// How many spans are sorted between progress reports.
+const progressInterval = 1000
+
+// Sorts spans like SortSpans, counting the sorted spans on progress when it
+// is not nil.
+func sortSpans(ctx context.Context, spans []ColorSpan, key SortKeyFunc, reverse bool, workers int, progress io.Writer) ([]ColorSpan, error) {
+	var sorted atomic.Int64
+	report := func() {
+		if n := sorted.Add(1); progress != nil && (n%progressInterval == 0 || int(n) == len(spans)) {
+			fmt.Fprintf(progress, "\rSorted %d/%d spans…", n, len(spans))
+		}
+	}
+
+	indices := make(chan int, len(spans))
+	for i := range spans {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+					return
 				}
-			})
+				span := spans[i]
+				if len(span.pixels) < 2 {
+					report()
+					continue
+				}
+				keys := span.keys
+				if keys == nil {
+					keys = make([]float64, len(span.pixels))
+					for i, c := range span.pixels {
+						keys[i] = key(c, span.points[i])
+					}
+				}
+				sort.Sort(spanSorter{span, keys, reverse})
+				report()
+			}
+		}()
+	}
+	wg.Wait()
+	if progress != nil && len(spans) > 0 {
+		fmt.Fprintln(progress)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var sortedSpans []ColorSpan = make([]ColorSpan, 0, len(spans))
+	for _, span := range spans {
+		if len(span.pixels) > 1 {
 			sortedSpans = append(sortedSpans, span)
 		}
 	}
 
-	return sortedSpans
+	return sortedSpans, nil
 }
 
-func applyHorizontalSpans(src image.Image, spans []ColorSpan) image.Image {
+func ApplyHorizontalSpans(ctx context.Context, src image.Image, spans []ColorSpan) (image.Image, error) {
 	b := src.Bounds()
-	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
-	draw.Draw(out, out.Bounds(), src, src.Bounds().Min, draw.Src)
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, src, b.Min, draw.Src)
 
-	for _, span := range spans {
+	for n, span := range spans {
+		if n%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		for i, c := range span.pixels {
-			out.Set(span.idx+i, span.id, c)
+			if span.reversed {
+				out.Set(b.Min.X+span.idx+len(span.pixels)-1-i, b.Min.Y+span.id, c)
+			} else {
+				out.Set(b.Min.X+span.idx+i, b.Min.Y+span.id, c)
+			}
 		}
 	}
 
-	return out
+	return out, nil
 }
 
-func applyVerticalSpans(src image.Image, spans []ColorSpan) image.Image {
+func ApplyVerticalSpans(ctx context.Context, src image.Image, spans []ColorSpan) (image.Image, error) {
 	b := src.Bounds()
-	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
-	draw.Draw(out, out.Bounds(), src, src.Bounds().Min, draw.Src)
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, src, b.Min, draw.Src)
 
-	for _, span := range spans {
+	for n, span := range spans {
+		if n%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		for i, c := range span.pixels {
-			out.Set(span.id, span.idx+i, c)
+			out.Set(b.Min.X+span.id, b.Min.Y+span.idx+i, c)
 		}
 	}
 
-	return out
+	return out, nil
 }
 
-func main() {
-	flag.Usage = func() {
-		w := flag.CommandLine.Output()
+func ApplyDiagonalSpans(ctx context.Context, src image.Image, spans []ColorSpan) (image.Image, error) {
+	b := src.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, src, b.Min, draw.Src)
 
-		fmt.Fprintf(w, "Usage: [options] <filename>\nOptions:\n")
-		getopt.PrintDefaults()
+	for n, span := range spans {
+		if n%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		start := b.Min.Add(diagonalStart(span.id, b.Dy()))
+		for i, c := range span.pixels {
+			out.Set(start.X+span.idx+i, start.Y+span.idx+i, c)
+		}
 	}
 
-	lowerthreshold := flag.Int("l", lowThreshold, "Lower perceived luminance threshold when generating a mask for the image.")
-	upperthreshold := flag.Int("u", highThreshold, "Upper perceived luminance threshold when generating a mask for the image.")
-	minspanlength := flag.Int("s", 2, "The minimum allowed length of span that should be sorted.")
-	spantype := flag.Int("t", 0, "The type of sorting to do, 0: horizontal, 1: vertical, 2: diagonal.")
-	keepmask := flag.Bool("m", false, "Produce an output file for the generated mask.")
-	inverted := flag.Bool("i", false, "Invert the mask for sortable image areas.")
-	reverse := flag.Bool("r", false, "Reverse the sorting direction.")
-	preserveformat := flag.Bool("p", false, "Produce output in the same image format of the provided input.")
+	return out, nil
+}
+
+// Options collects the parameters for every stage of the sorting pipeline.
+type Options struct {
+	// A mask to sort with, when nil one is generated from the mask options.
+	Mask image.Image
+	// When not empty, only this part of the image is sorted.
+	Region image.Rectangle
+	// Sorted instead of spans generated from a mask when not nil, and only
+	// for images of ImportedSize.
+	ImportedSpans []Span
+	ImportedSize  image.Point
+
+	MaskType       string
+	LowerThreshold int
+	UpperThreshold int
+	// Replaces the thresholds with the luminances at the AutoLow and AutoHigh
+	// percentiles of the image, given as fractions.
+	AutoThreshold       bool
+	AutoLow             float64
+	AutoHigh            float64
+	Invert              bool
+	MaskPreMedianFilter bool
+	MaskBlur            float64
+	MaskDilate          int
+	MaskErode           int
+	StripeSpacing       int
+	StripeWidth         int
+	StripeNoiseDensity  float64
+	CannyLow            float64
+	CannyHigh           float64
+	CannyBlurRadius     int
+	SdfInnerRadius      int
+	MotionAngle         int
+	ContourCount        int
+	ContourWidth        int
+	MotionLength        int
+	SdfOuterRadius      int
+	NearEdgeDistance    int
+	EdgeThreshold       float64
+	HueLow              float64
+	HueHigh             float64
+	SaturationLow       float64
+	SaturationHigh      float64
+	GradientAngle       float64
+	GradientLow         float64
+	GradientHigh        float64
+	NoiseScale          float64
+	NoiseLow            float64
+	NoiseHigh           float64
+	NoiseSeed           int64
+	VoronoiSeeds        int
+	VoronoiSeed         int64
+	Seed                int64
+
+	SpanType      SpanType
+	MinSpanLength int
+	MaxSpanLength int
+	// Tilts horizontal spans clockwise by this many degrees.
+	Angle                 float64
+	Zigzag                bool
+	TileWidth             int
+	TileHeight            int
+	Center                image.Point
+	SpiralSpacing         float64
+	FanSourceX            int
+	TextureRadius         int
+	TextureMaxShift       int
+	EllipseA              float64
+	EllipseB              float64
+	EllipseAngle          float64
+	LineStart             image.Point
+	LineEnd               image.Point
+	LineSpacing           int
+	Polygon               []image.Point
+	DiamondCenter         image.Point
+	GeodesicPaths         int
+	FlowMaxLength         int
+	QuadMaxDepth          int
+	QuadVarianceThreshold float64
+	TextLineDeviation     float64
+	GridCellWidth         int
+	GridCellHeight        int
+	GridCellDirectionMode string
+
+	// No sorting is done when Key is nil.
+	Key SortKeyFunc
+	// Sorts each color channel on its own instead of whole pixels by Key.
+	ChannelSort bool
+	// Rearranges each span in place of or after sorting.
+	Transform     func(ColorSpan) ColorSpan
+	NormalizeKeys bool
+	// Number of goroutines spans are sorted on, at least one.
+	Workers int
+	// When not nil, the number of spans found and sorted is reported to it.
+	Progress          io.Writer
+	Reverse           bool
+	RotateSpanBy      int
+	RotateSpanByIndex int
+	ScrambleFraction  float64
+	// Chance between 0 and 1 of each span being partially shuffled after
+	// sorting, also the fraction of its adjacent pixel pairs swapped.
+	Randomize         float64
+	SpectralNormalize bool
+	PreInvertHue      bool
+	// Inserted into the middle of every span when not nil.
+	InsertColor color.Color
+	// When positive only this many spans are written back to the image.
+	SpanLimit int
+	// Only every nth span is sorted when above 1.
+	SkipEveryN int
+
+	// Collects every span found when not nil.
+	spans *[]Span
+	// When not nil, receives the sorted spans so they can be applied again.
+	sorted *sortedSpans
+}
+
+// The spans of a finished sort, kept so that any number of them can be
+// written to the image without sorting again.
+type sortedSpans struct {
+	count int
+	// Writes the first n sorted spans to the image the sort was given.
+	apply func(n int) (image.Image, error)
+}
+
+var ErrUnimplementedSpanType = errors.New("Unimplemented sorting type.")
 
-	getopt.Aliases(
-		"l", "lower-threshold",
-		"u", "upper-threshold",
-		"s", "minimum-span-length",
-		"t", "span-type",
-		"m", "keep-mask",
-		"i", "invert",
-		"r", "reverse",
-		"p", "preserve-format",
-	)
+// A MaskGenerator marks the pixels of an image to sort, or those not to sort
+// when inverted.
+type MaskGenerator func(img image.Image, invert bool) (image.Image, error)
 
-	getopt.Parse()
-	if len(flag.Args()) != 1 {
-		flag.Usage()
-		os.Exit(0)
+// The mask types that threshold a property of each pixel and handle
+// inversion themselves, bound to their thresholds in opts.
+func thresholdMaskGenerators(opts Options) map[string]MaskGenerator {
+	return map[string]MaskGenerator{
+		"luminance": func(img image.Image, invert bool) (image.Image, error) {
+			return GenerateLuminanceMask(img, opts.LowerThreshold, opts.UpperThreshold, invert)
+		},
+		"hue": func(img image.Image, invert bool) (image.Image, error) {
+			return generateHueMask(img, opts.HueLow, opts.HueHigh, invert)
+		},
+		"saturation": func(img image.Image, invert bool) (image.Image, error) {
+			return generateSaturationMask(img, opts.SaturationLow, opts.SaturationHigh, invert)
+		},
+		"edge": func(img image.Image, invert bool) (image.Image, error) {
+			return generateEdgeMask(img, opts.EdgeThreshold, invert)
+		},
+		"gradient": func(img image.Image, invert bool) (image.Image, error) {
+			return generateLinearGradientMask(img, opts.GradientAngle, opts.GradientLow, opts.GradientHigh, invert)
+		},
+		"radial-gradient": func(img image.Image, invert bool) (image.Image, error) {
+			return generateRadialGradientMask(img, opts.GradientLow, opts.GradientHigh, invert)
+		},
+		"noise": func(img image.Image, invert bool) (image.Image, error) {
+			return generateNoiseMask(img.Bounds(), opts.NoiseScale, opts.NoiseLow, opts.NoiseHigh, opts.NoiseSeed, invert)
+		},
+		"voronoi": func(img image.Image, invert bool) (image.Image, error) {
+			return generateVoronoiMask(img, opts.VoronoiSeeds, opts.VoronoiSeed, invert)
+		},
 	}
-	filepath := flag.Args()[0]
+}
 
-	img, format, err := decodeImage(filepath)
+// Generates the mask selected by opts.MaskType, then erodes, dilates, and
+// blurs it as the mask options ask.
+func GenerateMask(img image.Image, opts Options) (image.Image, error) {
+	mask, err := generateMask(img, opts)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
+	mask = ErodeMask(mask, opts.MaskErode)
+	mask = DilateMask(mask, opts.MaskDilate)
+	return BlurMask(mask, opts.MaskBlur), nil
+}
+
+func generateMask(img image.Image, opts Options) (image.Image, error) {
+	var mask image.Image
+	var err error
 
-	mask, err := generateLuminanceMask(img, *lowerthreshold, *upperthreshold, *inverted)
+	if opts.MaskPreMedianFilter {
+		img = medianFilter3x3(img)
+	}
+
+	if opts.AutoThreshold {
+		if opts.AutoLow < 0 || opts.AutoLow > opts.AutoHigh || opts.AutoHigh > 1 {
+			return nil, errors.New("Automatic threshold percentiles must be ordered between 0 and 1.")
+		}
+		opts.LowerThreshold, opts.UpperThreshold = percentilesFromHistogram(computeLuminanceHistogram(img), opts.AutoLow, opts.AutoHigh)
+	}
+
+	if generate, ok := thresholdMaskGenerators(opts)[opts.MaskType]; ok {
+		return generate(img, opts.Invert)
+	}
+
+	switch opts.MaskType {
+	case "noisy-stripes":
+		if opts.StripeNoiseDensity < 0 || opts.StripeNoiseDensity > 1 {
+			return nil, errors.New("Stripe noise density must be between 0 and 1.")
+		}
+		mask, err = generateStripeMask(img.Bounds(), opts.StripeSpacing, opts.StripeWidth)
+		if err == nil {
+			rng := rand.New(rand.NewPCG(uint64(opts.Seed), 0))
+			mask = combineStripesAndNoise(mask, generateNoiseImage(img.Bounds(), rng), opts.StripeNoiseDensity)
+		}
+	case "canny":
+		mask, err = generateCannyMask(img, opts.CannyLow, opts.CannyHigh, opts.CannyBlurRadius)
+	case "motion-blur":
+		if opts.LowerThreshold > opts.UpperThreshold {
+			return nil, errors.New("Low threshold must be less than high threshold.")
+		}
+		if opts.LowerThreshold < 0 || opts.UpperThreshold < 0 {
+			return nil, errors.New("Threshold values must be positive.")
+		}
+		blurred := motionBlurLuminance(img, opts.MotionAngle, opts.MotionLength)
+		mask = thresholdMask(blurred, img.Bounds(), float64(opts.LowerThreshold), float64(opts.UpperThreshold))
+	case "contour-band":
+		mask, err = generateContourBandMask(img, opts.LowerThreshold, opts.UpperThreshold, opts.ContourCount, opts.ContourWidth)
+	case "sdf":
+		if opts.SdfInnerRadius > opts.SdfOuterRadius {
+			return nil, errors.New("SDF inner radius must be less than outer radius.")
+		}
+		if opts.SdfInnerRadius < 0 {
+			return nil, errors.New("SDF radii must be positive.")
+		}
+		mask, err = generateCannyMask(img, opts.CannyLow, opts.CannyHigh, opts.CannyBlurRadius)
+		if err == nil {
+			mask = generateSDFMask(mask, opts.SdfInnerRadius, opts.SdfOuterRadius)
+		}
+	case "near-edge":
+		if opts.NearEdgeDistance < 0 {
+			return nil, errors.New("Near edge distance must be positive.")
+		}
+		mask, err = generateCannyMask(img, opts.CannyLow, opts.CannyHigh, opts.CannyBlurRadius)
+		if err == nil {
+			mask = generateSDFMask(mask, 0, opts.NearEdgeDistance)
+		}
+	default:
+		return nil, fmt.Errorf("unknown mask type: %s", opts.MaskType)
+	}
 	if err != nil {
-		panic(err.Error())
+		return nil, err
+	}
+
+	if opts.Invert {
+		mask = invertMask(mask)
+	}
+	return mask, nil
+}
+
+// Keeps every nth span, starting with the first, so only those are sorted.
+// An n below 2 keeps every span.
+func filterSpans[S any](spans []S, n int) []S {
+	if n < 2 {
+		return spans
+	}
+
+	var kept []S = make([]S, 0, (len(spans)+n-1)/n)
+	for i := 0; i < len(spans); i += n {
+		kept = append(kept, spans[i])
+	}
+
+	return kept
+}
+
+// Runs every per-span step of the pipeline on the color spans of an image.
+func SortColorSpans(ctx context.Context, cspans []ColorSpan, opts Options) ([]ColorSpan, error) {
+	cspans = filterSpans(cspans, opts.SkipEveryN)
+	if opts.Progress != nil {
+		fmt.Fprintf(opts.Progress, "Spans found: %d\n", len(cspans))
+	}
+	if opts.spans != nil {
+		for _, span := range cspans {
+			*opts.spans = append(*opts.spans, Span{span.id, span.idx, len(span.pixels), span.reversed})
+		}
+	}
+	if opts.PreInvertHue {
+		for i, span := range cspans {
+			cspans[i] = applyHueInversion(span)
+		}
+	}
+	if opts.NormalizeKeys {
+		cspans = normalizeSpansSortKeys(cspans, opts.Key)
+	}
+	if opts.ChannelSort {
+		for i, span := range cspans {
+			cspans[i] = sortChannelsIndependently(span, opts.Reverse)
+		}
+	} else if opts.Key != nil {
+		var err error
+		cspans, err = sortSpans(ctx, cspans, opts.Key, opts.Reverse, opts.Workers, opts.Progress)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.ScrambleFraction > 0 {
+		rng := rand.New(rand.NewPCG(uint64(opts.Seed), 0))
+		for i, span := range cspans {
+			cspans[i] = partialShuffleSpan(span, opts.ScrambleFraction, false, rng)
+		}
+	}
+	if opts.Randomize > 0 {
+		rng := rand.New(rand.NewPCG(uint64(opts.Seed), 1))
+		for i, span := range cspans {
+			if len(span.pixels) > 1 && rng.Float64() < opts.Randomize {
+				cspans[i] = partialShuffleSpan(span, opts.Randomize, true, rng)
+			}
+		}
+	}
+	if opts.Transform != nil {
+		for i, span := range cspans {
+			cspans[i] = opts.Transform(span)
+		}
+	}
+	if opts.SpectralNormalize {
+		for i, span := range cspans {
+			cspans[i] = spectralNormalizeSpan(span)
+		}
+	}
+	if opts.InsertColor != nil {
+		for i, span := range cspans {
+			cspans[i] = insertMidpointColor(span, opts.InsertColor)
+		}
+	}
+	if opts.RotateSpanBy != 0 || opts.RotateSpanByIndex > 0 {
+		for i, span := range cspans {
+			n := opts.RotateSpanBy
+			if opts.RotateSpanByIndex > 0 {
+				n += i % opts.RotateSpanByIndex
+			}
+			cspans[i] = rotateSpan(span, n)
+		}
+	}
+	if opts.SpanLimit > 0 && opts.SpanLimit < len(cspans) {
+		cspans = cspans[:opts.SpanLimit]
+	}
+	return cspans, nil
+}
+
+// Sorts an image like SortImageContext, also returning the spans that were
+// found. Spans that do not lie along rows or columns are reported by their
+// length alone.
+func SortImageWithSpans(ctx context.Context, img image.Image, opts Options) (image.Image, []Span, error) {
+	var spans []Span = make([]Span, 0)
+	opts.spans = &spans
+	out, err := SortImageContext(ctx, img, opts)
+	return out, spans, err
+}
+
+// Summarizes how much of an image a set of spans covers.
+func SpanStats(spans []Span) (count int, totalPixels int, minLen int, maxLen int, avgLen float64) {
+	count = len(spans)
+	if count == 0 {
+		return 0, 0, 0, 0, 0
+	}
+
+	minLen = spans[0].len
+	for _, span := range spans {
+		totalPixels += span.len
+		minLen = min(minLen, span.len)
+		maxLen = max(maxLen, span.len)
+	}
+	avgLen = float64(totalPixels) / float64(count)
+
+	return count, totalPixels, minLen, maxLen, avgLen
+}
+
+func SortImage(img image.Image, opts Options) (image.Image, error) {
+	return SortImageContext(context.Background(), img, opts)
+}
+
+// Sorts only the pixels inside opts.Region, as if it were an image of its own,
+// leaving the rest of the image untouched.
+func sortRegion(ctx context.Context, img image.Image, opts Options) (image.Image, error) {
+	b := img.Bounds()
+	r := opts.Region
+	if !r.In(b) {
+		return nil, errors.New("Region must lie within the image.")
+	}
+
+	regionOpts := opts
+	regionOpts.Region = image.Rectangle{}
+	if opts.Mask != nil {
+		regionOpts.Mask = cropImage(opts.Mask, r)
+	}
+	// Positions seen by the sort key need moving back into the full image.
+	if opts.Key != nil {
+		regionOpts.Key = func(c color.Color, p image.Point) float64 {
+			return opts.Key(c, p.Add(r.Min))
+		}
+	}
+
+	sorted, err := SortImageContext(ctx, cropImage(img, r), regionOpts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.sorted != nil {
+		apply := opts.sorted.apply
+		opts.sorted.apply = func(n int) (image.Image, error) {
+			sorted, err := apply(n)
+			if err != nil {
+				return nil, err
+			}
+			return pasteRegion(img, r, sorted), nil
+		}
+	}
+
+	return pasteRegion(img, r, sorted), nil
+}
+
+// A copy of img with sorted drawn over the region r.
+func pasteRegion(img image.Image, r image.Rectangle, sorted image.Image) image.Image {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, img, b.Min, draw.Src)
+	draw.Draw(out, r, sorted, image.Point{}, draw.Src)
+	return out
+}
+
+// Sorts an image, stopping early with the error of ctx once it is done.
+func SortImageContext(ctx context.Context, img image.Image, opts Options) (image.Image, error) {
+	if opts.ScrambleFraction < 0 || opts.ScrambleFraction > 1 {
+		return nil, errors.New("Scramble fraction must be between 0 and 1.")
+	}
+	if opts.Randomize < 0 || opts.Randomize > 1 {
+		return nil, errors.New("Randomize amount must be between 0 and 1.")
+	}
+
+	if !opts.Region.Empty() {
+		return sortRegion(ctx, img, opts)
+	}
+	if opts.ImportedSpans != nil {
+		return sortImportedSpans(ctx, img, opts)
+	}
+
+	var err error
+	mask := opts.Mask
+	if mask == nil {
+		mask, err = GenerateMask(img, opts)
+		if err != nil {
+			return nil, err
+		}
+		opts.Mask = mask
 	}
 
 	var spans []Span
+	var pspans []PixelSpan
 	var cspans []ColorSpan
 	var out image.Image
-	switch SpanType(*spantype) {
+	// Writes sorted spans over the image, set by every span type but grid.
+	var apply func(cspans []ColorSpan) (image.Image, error)
+	switch opts.SpanType {
 	case Horizontal:
-		spans = generateHorizontalSpans(mask, *minspanlength)
-		cspans = generateHorizontalColorSpans(img, spans)
-		cspans = sortSpans(cspans, *reverse)
-		out = applyHorizontalSpans(img, cspans)
+		if opts.Angle != 0 {
+			spans, err = generateAngledSpans(ctx, mask, opts.Angle, opts.MinSpanLength)
+			if err != nil {
+				return nil, err
+			}
+			cspans = generateAngledColorSpans(img, spans, opts.Angle)
+			cspans, err = SortColorSpans(ctx, cspans, opts)
+			if err != nil {
+				return nil, err
+			}
+			apply = func(cspans []ColorSpan) (image.Image, error) {
+				return applyAngledSpans(ctx, img, cspans, opts.Angle)
+			}
+			break
+		}
+		if opts.TileWidth > 0 || opts.TileHeight > 0 {
+			spans, err = generateTiledHorizontalSpans(ctx, mask, opts.TileWidth, opts.TileHeight, opts.MinSpanLength)
+		} else {
+			spans, err = GenerateHorizontalSpans(ctx, mask, opts.MinSpanLength, opts.MaxSpanLength, opts.Zigzag)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cspans = GenerateHorizontalColorSpans(img, spans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return ApplyHorizontalSpans(ctx, img, cspans)
+		}
 	case Vertical:
-		spans = generateVerticalSpans(mask, *minspanlength)
-		cspans = generateVerticalColorSpans(img, spans)
-		cspans = sortSpans(cspans, *reverse)
-		out = applyVerticalSpans(img, cspans)
+		spans, err = GenerateVerticalSpans(ctx, mask, opts.MinSpanLength, opts.MaxSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = GenerateVerticalColorSpans(img, spans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return ApplyVerticalSpans(ctx, img, cspans)
+		}
+	case Diagonal:
+		spans, err = GenerateDiagonalSpans(ctx, mask, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = GenerateDiagonalColorSpans(img, spans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return ApplyDiagonalSpans(ctx, img, cspans)
+		}
+	case Radial:
+		spans, err = generateRadialSpans(ctx, mask, opts.Center.X, opts.Center.Y, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = generateRadialColorSpans(img, spans, opts.Center.X, opts.Center.Y)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applyRadialSpans(ctx, img, cspans, opts.Center.X, opts.Center.Y)
+		}
+	case Spiral:
+		if opts.SpiralSpacing <= 0 {
+			return nil, errors.New("Spiral spacing must be positive.")
+		}
+		spans, err = generateSpiralSpans(ctx, mask, opts.SpiralSpacing, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = generateSpiralColorSpans(img, spans, opts.SpiralSpacing)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applySpiralSpans(ctx, img, cspans, opts.SpiralSpacing)
+		}
+	case TextureAware:
+		textureMap := computeTextureMap(img, opts.TextureRadius)
+		spans, err = generateTextureAwareSpans(ctx, mask, textureMap, opts.TextureMaxShift, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = GenerateHorizontalColorSpans(img, spans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return ApplyHorizontalSpans(ctx, img, cspans)
+		}
+	case TextLine:
+		spans, err = generateTextLineSpans(ctx, img, mask, opts.TextLineDeviation, opts.MinSpanLength, opts.MaxSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = GenerateHorizontalColorSpans(img, spans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return ApplyHorizontalSpans(ctx, img, cspans)
+		}
+	case Ellipse:
+		pspans, err = generateEllipseSpans(ctx, mask, opts.EllipseA, opts.EllipseB, opts.EllipseAngle, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = generatePathColorSpans(img, pspans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applyPathSpans(ctx, img, pspans, cspans)
+		}
+	case Fan:
+		pspans, err = generateFanSpans(ctx, mask, opts.FanSourceX, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = generatePathColorSpans(img, pspans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applyPathSpans(ctx, img, pspans, cspans)
+		}
+	case BresenhamLine:
+		pspans, err = generateBresenhamLineSpans(ctx, mask, opts.LineStart, opts.LineEnd, opts.LineSpacing, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = generatePathColorSpans(img, pspans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applyPathSpans(ctx, img, pspans, cspans)
+		}
+	case WatershedBoundary:
+		pspans, err = generateWatershedBoundarySpans(ctx, img, mask, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = generatePathColorSpans(img, pspans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applyPathSpans(ctx, img, pspans, cspans)
+		}
+	case Polygon:
+		if len(opts.Polygon) < 3 {
+			return nil, errors.New("A polygon needs at least three vertices.")
+		}
+		mask, err = CombineMasks(mask, generatePolygonMask(img.Bounds(), opts.Polygon), MaskAnd)
+		if err != nil {
+			return nil, err
+		}
+		spans, err = GenerateHorizontalSpans(ctx, mask, opts.MinSpanLength, opts.MaxSpanLength, opts.Zigzag)
+		if err != nil {
+			return nil, err
+		}
+		cspans = GenerateHorizontalColorSpans(img, spans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return ApplyHorizontalSpans(ctx, img, cspans)
+		}
+	case Diamond:
+		pspans, err = generateDiamondSpans(ctx, mask, opts.DiamondCenter.X, opts.DiamondCenter.Y, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = generatePathColorSpans(img, pspans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applyPathSpans(ctx, img, pspans, cspans)
+		}
+	case Geodesic:
+		pspans, err = generateGeodesicSpans(ctx, img, mask, opts.GeodesicPaths, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = generatePathColorSpans(img, pspans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applyPathSpans(ctx, img, pspans, cspans)
+		}
+	case Flow:
+		if opts.FlowMaxLength < 1 {
+			return nil, errors.New("Flow max length must be positive.")
+		}
+		pspans, err = generateFlowSpans(ctx, img, mask, opts.FlowMaxLength, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = generatePathColorSpans(img, pspans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applyPathSpans(ctx, img, pspans, cspans)
+		}
+	case QuadTree:
+		pspans, err = generateQuadTreeSpans(ctx, img, mask, opts.QuadMaxDepth, opts.QuadVarianceThreshold, opts.MinSpanLength)
+		if err != nil {
+			return nil, err
+		}
+		cspans = generatePathColorSpans(img, pspans)
+		cspans, err = SortColorSpans(ctx, cspans, opts)
+		if err != nil {
+			return nil, err
+		}
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applyPathSpans(ctx, img, pspans, cspans)
+		}
+	case Grid:
+		if opts.GridCellWidth < 1 || opts.GridCellHeight < 1 {
+			return nil, errors.New("Grid cell dimensions must be positive.")
+		}
+		switch opts.GridCellDirectionMode {
+		case "checkerboard", "random", "horizontal", "vertical":
+		default:
+			return nil, fmt.Errorf("unknown grid cell direction mode: %s", opts.GridCellDirectionMode)
+		}
+		out, err = sortGrid(ctx, img, opts.GridCellWidth, opts.GridCellHeight, opts)
 	default:
-		fmt.Println("Unimplemented sorting type.")
-		os.Exit(0)
+		return nil, ErrUnimplementedSpanType
 	}
 
-	if !*preserveformat {
-		format = "png"
-	}
-	err = encodeImage(fmt.Sprintf("./output/out.%s", format), out, format)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	if *keepmask {
-		err = encodeImage(fmt.Sprintf("./output/mask.%s", format), mask, format)
+	if apply != nil {
+		if opts.sorted != nil {
+			*opts.sorted = sortedSpans{len(cspans), func(n int) (image.Image, error) {
+				return apply(cspans[:n])
+			}}
+		}
+		out, err = apply(cspans)
 		if err != nil {
-			panic(err.Error())
+			return nil, err
 		}
 	}
+
+	return out, nil
 }
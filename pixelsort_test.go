@@ -0,0 +1,356 @@
+package pixelsort
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// A mask of the given size with every pixel sortable.
+func whiteMask(b image.Rectangle) *image.RGBA {
+	mask := image.NewRGBA(b)
+	draw.Draw(mask, b, image.NewUniform(RGBAWhite), image.Point{}, draw.Src)
+	return mask
+}
+
+// An image whose pixels all differ, so sorting them is observable.
+func testImage(b image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 37 % 256), uint8(y * 23 % 256), uint8((x + y) * 11 % 256), 255})
+		}
+	}
+	return img
+}
+
+func TestSortImage(t *testing.T) {
+	b := image.Rect(0, 0, 10, 10)
+	img := testImage(b)
+	red, err := MakeComparator("red")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultConfig().Options
+	opts.Mask = whiteMask(b)
+	opts.Key = byColor(red)
+
+	out, err := SortImage(img, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bounds() != b {
+		t.Fatalf("bounds = %v, want %v", out.Bounds(), b)
+	}
+
+	for y := range b.Dy() {
+		var before, after []uint32
+		for x := range b.Dx() {
+			r, _, _, _ := img.At(x, y).RGBA()
+			before = append(before, r)
+			r, _, _, _ = out.At(x, y).RGBA()
+			after = append(after, r)
+		}
+		if !slices.IsSortedFunc(after, func(a, b uint32) int { return int(b) - int(a) }) {
+			t.Errorf("row %d is not sorted by descending red: %v", y, after)
+		}
+		slices.Sort(before)
+		slices.Sort(after)
+		if !slices.Equal(before, after) {
+			t.Errorf("row %d does not hold the same pixels after sorting", y)
+		}
+	}
+}
+
+func TestBMPRoundTrip(t *testing.T) {
+	img := testImage(image.Rect(0, 0, 7, 5))
+	filename := filepath.Join(t.TempDir(), "round-trip.bmp")
+	err := EncodeImage(filename, img, "bmp", EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, format, err := DecodeImage(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "bmp" {
+		t.Errorf("format = %s, want bmp", format)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Fatalf("bounds = %v, want %v", decoded.Bounds(), img.Bounds())
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			got := color.RGBAModel.Convert(decoded.At(x, y))
+			if want := img.At(x, y); got != want {
+				t.Errorf("pixel at (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+// Sorting a sub-image should give the same pixels as sorting a copy of it
+// whose bounds start at the origin.
+func TestSortSubImage(t *testing.T) {
+	sub := testImage(image.Rect(0, 0, 60, 50)).SubImage(image.Rect(10, 12, 50, 42))
+	b := sub.Bounds()
+	origin := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(origin, origin.Bounds(), sub, b.Min, draw.Src)
+
+	opts := defaultConfig().Options
+	opts.Polygon = []image.Point{{2, 3}, {35, 5}, {20, 28}}
+
+	check := func(t *testing.T, opts Options) {
+		want, err := SortImage(origin, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := SortImage(sub, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Bounds() != b {
+			t.Fatalf("bounds = %v, want %v", got.Bounds(), b)
+		}
+		for y := range b.Dy() {
+			for x := range b.Dx() {
+				g := color.RGBAModel.Convert(got.At(b.Min.X+x, b.Min.Y+y))
+				w := color.RGBAModel.Convert(want.At(x, y))
+				if g != w {
+					t.Fatalf("pixel at (%d, %d) = %v, want %v", x, y, g, w)
+				}
+			}
+		}
+	}
+
+	for name, spanType := range spanTypeNames {
+		t.Run("span type "+name, func(t *testing.T) {
+			opts := opts
+			opts.SpanType = spanType
+			check(t, opts)
+		})
+	}
+	t.Run("angled spans", func(t *testing.T) {
+		opts := opts
+		opts.Angle = 30
+		check(t, opts)
+	})
+	for _, maskType := range []string{
+		"luminance", "noisy-stripes", "canny", "sdf", "motion-blur", "contour-band", "near-edge",
+		"edge", "hue", "saturation", "noise", "gradient", "radial-gradient", "voronoi",
+	} {
+		t.Run("mask type "+maskType, func(t *testing.T) {
+			opts := opts
+			opts.MaskType = maskType
+			check(t, opts)
+		})
+	}
+}
+
+func TestMaxSpanLength(t *testing.T) {
+	want := []int{7, 7, 6}
+
+	spans, err := GenerateHorizontalSpans(context.Background(), whiteMask(image.Rect(0, 0, 20, 1)), 1, 7, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := spanLengths(spans); !slices.Equal(got, want) {
+		t.Errorf("horizontal span lengths = %v, want %v", got, want)
+	}
+
+	spans, err = GenerateVerticalSpans(context.Background(), whiteMask(image.Rect(0, 0, 1, 20)), 1, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := spanLengths(spans); !slices.Equal(got, want) {
+		t.Errorf("vertical span lengths = %v, want %v", got, want)
+	}
+	for i, idx := range []int{0, 7, 14} {
+		if spans[i].idx != idx {
+			t.Errorf("span %d starts at %d, want %d", i, spans[i].idx, idx)
+		}
+	}
+}
+
+// Every span should get exactly one color span holding its pixels.
+func TestGenerateColorSpans(t *testing.T) {
+	b := image.Rect(0, 0, 8, 6)
+	img := testImage(b)
+	mask := image.NewRGBA(b)
+	for y := range b.Dy() {
+		for x := range b.Dx() {
+			if (x+y)%3 == 0 {
+				mask.Set(x, y, RGBABlack)
+			} else {
+				mask.Set(x, y, RGBAWhite)
+			}
+		}
+	}
+
+	check := func(t *testing.T, spans []Span, cspans []ColorSpan) {
+		if len(spans) == 0 {
+			t.Fatal("no spans were generated")
+		}
+		if len(cspans) != len(spans) {
+			t.Fatalf("%d color spans for %d spans", len(cspans), len(spans))
+		}
+		for i, cspan := range cspans {
+			if cspan.pixels == nil || len(cspan.pixels) != spans[i].len {
+				t.Errorf("color span %d has %d pixels, want %d", i, len(cspan.pixels), spans[i].len)
+			}
+		}
+	}
+
+	t.Run("horizontal", func(t *testing.T) {
+		spans, err := GenerateHorizontalSpans(context.Background(), mask, 1, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, spans, GenerateHorizontalColorSpans(img, spans))
+	})
+	t.Run("vertical", func(t *testing.T) {
+		spans, err := GenerateVerticalSpans(context.Background(), mask, 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, spans, GenerateVerticalColorSpans(img, spans))
+	})
+}
+
+func spanLengths(spans []Span) []int {
+	var lengths []int
+	for _, span := range spans {
+		lengths = append(lengths, span.len)
+	}
+	return lengths
+}
+
+func BenchmarkSortSpans(b *testing.B) {
+	bounds := image.Rect(0, 0, 2000, 1500)
+	img := testImage(bounds)
+	spans, err := GenerateHorizontalSpans(context.Background(), whiteMask(bounds), 1, 0, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	opts := defaultConfig().Options
+
+	b.ResetTimer()
+	for range b.N {
+		b.StopTimer()
+		cspans := GenerateHorizontalColorSpans(img, spans)
+		b.StartTimer()
+		_, err := SortSpans(context.Background(), cspans, opts.Key, false, opts.Workers)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestInsertMidpointColor(t *testing.T) {
+	img := testImage(image.Rect(0, 0, 6, 1))
+	mask := image.NewRGBA(img.Bounds())
+	draw.Draw(mask, mask.Bounds(), image.NewUniform(RGBABlack), image.Point{}, draw.Src)
+	draw.Draw(mask, image.Rect(0, 0, 4, 1), image.NewUniform(RGBAWhite), image.Point{}, draw.Src)
+	insert := color.RGBA{255, 0, 255, 255}
+
+	opts := defaultConfig().Options
+	opts.Mask = mask
+	opts.Key = nil
+	opts.InsertColor = insert
+	out, err := SortImage(img, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []color.Color{img.At(0, 0), img.At(1, 0), insert, img.At(2, 0), img.At(3, 0), img.At(5, 0)}
+	for x, w := range want {
+		if got := out.At(x, 0); got != w {
+			t.Errorf("pixel %d = %v, want %v", x, got, w)
+		}
+	}
+}
+
+// Each frame should match a sort limited to the spans it has written so far.
+func TestSortImageFrames(t *testing.T) {
+	b := image.Rect(0, 0, 9, 7)
+	img := testImage(b)
+	opts := defaultConfig().Options
+	opts.Mask = whiteMask(b)
+
+	// Seven rows of spans, or at most four in each grid cell, two per frame.
+	for name, test := range map[string]struct {
+		spanType SpanType
+		frames   int
+	}{"horizontal": {Horizontal, 5}, "grid": {Grid, 3}} {
+		t.Run(name, func(t *testing.T) {
+			opts := opts
+			opts.SpanType = test.spanType
+			opts.GridCellWidth, opts.GridCellHeight = 4, 4
+			frames, err := SortImageFrames(context.Background(), img, opts, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(frames) != test.frames {
+				t.Fatalf("%d frames, want %d", len(frames), test.frames)
+			}
+			for i, frame := range frames[1:] {
+				opts := opts
+				if i < len(frames)-2 {
+					opts.SpanLimit = 2 * (i + 1)
+				}
+				want, err := SortImage(img, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for y := b.Min.Y; y < b.Max.Y; y++ {
+					for x := b.Min.X; x < b.Max.X; x++ {
+						g := color.RGBAModel.Convert(frame.At(x, y))
+						w := color.RGBAModel.Convert(want.At(x, y))
+						if g != w {
+							t.Fatalf("frame %d pixel at (%d, %d) = %v, want %v", i+1, x, y, g, w)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// Shuffling a span should move its points and keys along with its pixels,
+// the same way partialShuffle moves the pixels alone.
+func TestPartialShuffleSpan(t *testing.T) {
+	img := testImage(image.Rect(0, 0, 20, 1))
+	spans, err := GenerateHorizontalSpans(context.Background(), whiteMask(img.Bounds()), 1, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, amount := range []float64{0, 0.5, 1} {
+		span := GenerateHorizontalColorSpans(img, spans)[0]
+		span.keys = make([]float64, len(span.pixels))
+		for i := range span.keys {
+			span.keys[i] = float64(i)
+		}
+		pixels := partialShuffle(slices.Clone(span.pixels), amount, rand.New(rand.NewPCG(7, 0)))
+		span = partialShuffleSpan(span, amount, true, rand.New(rand.NewPCG(7, 0)))
+
+		if !slices.Equal(span.pixels, pixels) {
+			t.Errorf("amount %v: span pixels = %v, want %v", amount, span.pixels, pixels)
+		}
+		for i, p := range span.points {
+			if span.pixels[i] != img.At(p.X, p.Y) {
+				t.Errorf("amount %v: pixel %d came from %v but does not match it", amount, i, p)
+			}
+			if span.keys[i] != float64(p.X) {
+				t.Errorf("amount %v: key %d = %v, want %d", amount, i, span.keys[i], p.X)
+			}
+		}
+	}
+}
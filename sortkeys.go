@@ -0,0 +1,661 @@
+package pixelsort
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// Sort keys are given each pixel along with the position it was read from in
+// the source image.
+type SortKeyFunc func(c color.Color, p image.Point) float64
+
+// A PixelComparator maps a pixel to the value it is ordered by.
+type PixelComparator func(color.Color) float64
+
+func MakeComparator(name string) (PixelComparator, error) {
+	switch name {
+	case "hue":
+		return getHue, nil
+	case "luminance":
+		return getPerceivedLuminance, nil
+	case "saturation":
+		return getSaturation, nil
+	case "oklab-l":
+		return getOklabLightness, nil
+	case "oklab-c":
+		return getOklabChroma, nil
+	case "red":
+		return func(c color.Color) float64 {
+			r, _, _, _ := c.RGBA()
+			return float64(r)
+		}, nil
+	case "green":
+		return func(c color.Color) float64 {
+			_, g, _, _ := c.RGBA()
+			return float64(g)
+		}, nil
+	case "blue":
+		return func(c color.Color) float64 {
+			_, _, b, _ := c.RGBA()
+			return float64(b)
+		}, nil
+	case "alpha":
+		return func(c color.Color) float64 {
+			_, _, _, a := c.RGBA()
+			return float64(a)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown comparator: %s", name)
+	}
+}
+
+// Adapts a sort key that only depends on the color of a pixel.
+func byColor(key PixelComparator) SortKeyFunc {
+	return func(c color.Color, _ image.Point) float64 {
+		return key(c)
+	}
+}
+
+// Precomputes a sort key for every pixel in the bounds that only depends on
+// where the pixel is, for keys too expensive to compute during sorting.
+func byPosition(b image.Rectangle, key func(x, y int) float64) SortKeyFunc {
+	values := make([][]float64, b.Dy())
+	for y := range values {
+		values[y] = make([]float64, b.Dx())
+		for x := range values[y] {
+			values[y][x] = key(b.Min.X+x, b.Min.Y+y)
+		}
+	}
+	return func(_ color.Color, p image.Point) float64 {
+		return values[p.Y-b.Min.Y][p.X-b.Min.X]
+	}
+}
+
+// Tuning values for the sort keys that take parameters.
+type SortKeyParams struct {
+	GreyThreshold            float64
+	GreyPosition             float64
+	Reference                image.Image
+	SaturationContrastRadius int
+	LumaPeriod               float64
+	HueBuckets               int
+	SpatialAlpha             float64
+	// Compared against by the color-distance sort key.
+	SortColor *color.RGBA
+}
+
+func MakeSortKey(name string, img image.Image, params SortKeyParams) (SortKeyFunc, error) {
+	switch name {
+	case "none":
+		return nil, nil
+	case "hue-with-grey-neutral":
+		if params.GreyPosition < 0 || params.GreyPosition > 1 {
+			return nil, errors.New("Grey key position must be between 0 and 1.")
+		}
+		return byColor(func(c color.Color) float64 {
+			return getHueWithGreyNeutral(c, params.GreyThreshold, params.GreyPosition)
+		}), nil
+	case "distance-from-white":
+		return byColor(getRGBDistanceFromWhite), nil
+	case "color-distance":
+		if params.SortColor == nil {
+			return nil, errors.New("The color-distance sort key requires a sort color.")
+		}
+		ref := *params.SortColor
+		// Negated so the default descending sort puts the closest colors first.
+		return byColor(func(c color.Color) float64 {
+			return -getColorDistance(c, ref)
+		}), nil
+	case "harmonic-mean":
+		return byColor(getHarmonicMeanRGB), nil
+	case "geometric-mean":
+		return byColor(getGeometricMeanRGB), nil
+	case "luma-contrast-enhancement":
+		return byColor(getLuma), nil
+	case "luma-periodic":
+		if params.LumaPeriod <= 0 {
+			return nil, errors.New("Luma period must be greater than 0.")
+		}
+		return byColor(func(c color.Color) float64 {
+			return getLumaPeriodic(c, params.LumaPeriod)
+		}), nil
+	case "hue-then-luma":
+		if params.HueBuckets < 1 {
+			return nil, errors.New("Hue buckets must be at least 1.")
+		}
+		return byColor(func(c color.Color) float64 {
+			return getHueThenLuma(c, params.HueBuckets)
+		}), nil
+	case "spatial-hue":
+		if params.SpatialAlpha < 0 || params.SpatialAlpha > 1 {
+			return nil, errors.New("Spatial alpha must be between 0 and 1.")
+		}
+		b := img.Bounds()
+		return func(c color.Color, p image.Point) float64 {
+			return getSpatialHueWeight(c, p.X-b.Min.X, b.Dx(), params.SpatialAlpha)
+		}, nil
+	case "saturation-contrast":
+		return byPosition(img.Bounds(), func(x, y int) float64 {
+			return getLocalSaturationContrast(img, x, y, params.SaturationContrastRadius)
+		}), nil
+	case "compressed-size":
+		return byPosition(img.Bounds(), func(x, y int) float64 {
+			return getLocalCompressibility(img, x, y)
+		}), nil
+	case "oklab-hue":
+		return byColor(getOklabHue), nil
+	case "rgb-interleave":
+		return byColor(getMortonCode), nil
+	case "dominant-wavelength":
+		return byColor(getDominantWavelength), nil
+	case "pca":
+		axis := computePrincipalAxis(img)
+		return byColor(func(c color.Color) float64 {
+			return projectOntoAxis(c, axis)
+		}), nil
+	case "reference-distance":
+		if params.Reference == nil {
+			return nil, errors.New("The reference-distance sort key requires a reference image.")
+		}
+		if params.Reference.Bounds() != img.Bounds() {
+			return nil, errors.New("Reference image dimensions must match the input image.")
+		}
+		return func(c color.Color, p image.Point) float64 {
+			return getCIEDE2000(c, params.Reference.At(p.X, p.Y))
+		}, nil
+	default:
+		comparator, err := MakeComparator(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown sort key: %s", name)
+		}
+		return byColor(comparator), nil
+	}
+}
+
+// Finds the dominant axis of the image's colors in RGB space using power
+// iteration on the 3x3 covariance matrix of every pixel.
+func computePrincipalAxis(img image.Image) [3]float64 {
+	b := img.Bounds()
+	n := float64(b.Dx() * b.Dy())
+	if n == 0 {
+		return [3]float64{1 / math.Sqrt(3), 1 / math.Sqrt(3), 1 / math.Sqrt(3)}
+	}
+
+	var mean [3]float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			mean[0] += float64(r)
+			mean[1] += float64(g)
+			mean[2] += float64(bl)
+		}
+	}
+	for i := range mean {
+		mean[i] /= n
+	}
+
+	var cov [3][3]float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			d := [3]float64{float64(r) - mean[0], float64(g) - mean[1], float64(bl) - mean[2]}
+			for i := range 3 {
+				for j := range 3 {
+					cov[i][j] += d[i] * d[j]
+				}
+			}
+		}
+	}
+
+	// Start from the grey axis so uniform images fall back to a brightness sort.
+	axis := [3]float64{1 / math.Sqrt(3), 1 / math.Sqrt(3), 1 / math.Sqrt(3)}
+	for range 100 {
+		var next [3]float64
+		for i := range 3 {
+			for j := range 3 {
+				next[i] += cov[i][j] * axis[j]
+			}
+		}
+		norm := math.Sqrt(next[0]*next[0] + next[1]*next[1] + next[2]*next[2])
+		if norm == 0 {
+			break
+		}
+		for i := range next {
+			next[i] /= norm
+		}
+		axis = next
+	}
+
+	// The sign of an eigenvector is arbitrary, point it towards white so the
+	// sort direction is consistent between images.
+	if axis[0]+axis[1]+axis[2] < 0 {
+		for i := range axis {
+			axis[i] = -axis[i]
+		}
+	}
+
+	return axis
+}
+
+func projectOntoAxis(c color.Color, axis [3]float64) float64 {
+	r, g, b, _ := c.RGBA()
+	return float64(r)*axis[0] + float64(g)*axis[1] + float64(b)*axis[2]
+}
+
+// HSV saturation in [0, 1], the range of the RGB components relative to the
+// largest. Achromatic pixels, including black, have no saturation.
+func getSaturation(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	max := math.Max(math.Max(float64(r), float64(g)), float64(b))
+	min := math.Min(math.Min(float64(r), float64(g)), float64(b))
+	if max == 0 {
+		return 0
+	}
+	return (max - min) / max
+}
+
+// Hue normalized to [0, 1], except near-grey pixels whose hue is meaningless
+// all share the fixed greyPosition key instead.
+func getHueWithGreyNeutral(c color.Color, greyThreshold, greyPosition float64) float64 {
+	if getSaturation(c) < greyThreshold {
+		return greyPosition
+	}
+	return getHue(c) / 360
+}
+
+// Euclidean distance from pure white in RGB space.
+func getRGBDistanceFromWhite(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return math.Sqrt(math.Pow(65535-float64(r), 2) + math.Pow(65535-float64(g), 2) + math.Pow(65535-float64(b), 2))
+}
+
+// Euclidean distance from ref in sRGB space.
+func getColorDistance(c color.Color, ref color.RGBA) float64 {
+	r, g, b, _ := c.RGBA()
+	rr, rg, rb, _ := ref.RGBA()
+	return math.Sqrt(math.Pow(float64(r)-float64(rr), 2) + math.Pow(float64(g)-float64(rg), 2) + math.Pow(float64(b)-float64(rb), 2))
+}
+
+// Harmonic mean of the channels, dominated by the weakest channel.
+func getHarmonicMeanRGB(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	if r == 0 || g == 0 || b == 0 {
+		return 0
+	}
+	return 3.0 / (1/float64(r) + 1/float64(g) + 1/float64(b))
+}
+
+// Geometric mean of the channels, zero when any channel is zero.
+func getGeometricMeanRGB(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	if r == 0 || g == 0 || b == 0 {
+		return 0
+	}
+	return math.Cbrt(float64(r) * float64(g) * float64(b))
+}
+
+// Converts an sRGB channel value to linear light in [0, 1].
+func linearize(v uint32) float64 {
+	s := float64(v) / 65535
+	if s <= 0.04045 {
+		return s / 12.92
+	}
+	return math.Pow((s+0.055)/1.055, 2.4)
+}
+
+// The inverse of linearize, encoding linear light back to a 16 bit sRGB
+// channel.
+func delinearize(l float64) uint16 {
+	var s float64
+	if l <= 0.0031308 {
+		s = l * 12.92
+	} else {
+		s = 1.055*math.Pow(l, 1/2.4) - 0.055
+	}
+	return uint16(math.Round(math.Max(0, math.Min(1, s)) * 65535))
+}
+
+// https://en.wikipedia.org/wiki/SRGB
+// CIE 1931 XYZ of an sRGB color, scaled so white has Y = 1.
+func getXYZ(c color.Color) (float64, float64, float64) {
+	r, g, b, _ := c.RGBA()
+	lr, lg, lb := linearize(r), linearize(g), linearize(b)
+	x := 0.4124564*lr + 0.3575761*lg + 0.1804375*lb
+	y := 0.2126729*lr + 0.7151522*lg + 0.0721750*lb
+	z := 0.0193339*lr + 0.1191920*lg + 0.9503041*lb
+	return x, y, z
+}
+
+// https://en.wikipedia.org/wiki/CIELAB_color_space
+// Relative to the D65 white point.
+func getLab(c color.Color) (float64, float64, float64) {
+	x, y, z := getXYZ(c)
+	x /= 0.95047
+	z /= 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// https://en.wikipedia.org/wiki/Color_difference#CIEDE2000
+func getCIEDE2000(c1, c2 color.Color) float64 {
+	l1, a1, b1 := getLab(c1)
+	l2, a2, b2 := getLab(c2)
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	deg := func(y, x float64) float64 {
+		if x == 0 && y == 0 {
+			return 0
+		}
+		h := math.Atan2(y, x) * 180 / math.Pi
+		if h < 0 {
+			h += 360
+		}
+		return h
+	}
+	pow7 := func(v float64) float64 { return math.Pow(v, 7) }
+
+	cBar := (math.Hypot(a1, b1) + math.Hypot(a2, b2)) / 2
+	g := 0.5 * (1 - math.Sqrt(pow7(cBar)/(pow7(cBar)+pow7(25))))
+	a1p, a2p := (1+g)*a1, (1+g)*a2
+	c1p, c2p := math.Hypot(a1p, b1), math.Hypot(a2p, b2)
+	h1p, h2p := deg(b1, a1p), deg(b2, a2p)
+
+	dLp := l2 - l1
+	dCp := c2p - c1p
+	var dhp float64
+	if c1p*c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180 {
+			dhp -= 360
+		} else if dhp < -180 {
+			dhp += 360
+		}
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(rad(dhp/2))
+
+	lBarp := (l1 + l2) / 2
+	cBarp := (c1p + c2p) / 2
+	var hBarp float64
+	if c1p*c2p == 0 {
+		hBarp = h1p + h2p
+	} else if math.Abs(h1p-h2p) <= 180 {
+		hBarp = (h1p + h2p) / 2
+	} else if h1p+h2p < 360 {
+		hBarp = (h1p + h2p + 360) / 2
+	} else {
+		hBarp = (h1p + h2p - 360) / 2
+	}
+
+	t := 1 - 0.17*math.Cos(rad(hBarp-30)) + 0.24*math.Cos(rad(2*hBarp)) + 0.32*math.Cos(rad(3*hBarp+6)) - 0.20*math.Cos(rad(4*hBarp-63))
+	dTheta := 30 * math.Exp(-math.Pow((hBarp-275)/25, 2))
+	rc := 2 * math.Sqrt(pow7(cBarp)/(pow7(cBarp)+pow7(25)))
+	sl := 1 + 0.015*math.Pow(lBarp-50, 2)/math.Sqrt(20+math.Pow(lBarp-50, 2))
+	sc := 1 + 0.045*cBarp
+	sh := 1 + 0.015*cBarp*t
+	rt := -math.Sin(rad(2*dTheta)) * rc
+
+	return math.Sqrt(math.Pow(dLp/sl, 2) + math.Pow(dCp/sc, 2) + math.Pow(dHp/sh, 2) + rt*(dCp/sc)*(dHp/sh))
+}
+
+// Perceived luminance normalized to [0, 1].
+func getLuma(c color.Color) float64 {
+	return getPerceivedLuminance(c) / 65535
+}
+
+// Folds luma back on itself every period, so sorted spans cycle between dark
+// and bright bands.
+func getLumaPeriodic(c color.Color, period float64) float64 {
+	return math.Sin(2 * math.Pi * getLuma(c) / period)
+}
+
+// The largest value getLuma returns, that of white.
+const maxLuma = 1
+
+// Groups pixels into buckets of similar hue, ordered by luma within each
+// bucket. Buckets are spaced maxLuma apart, so the brightest pixel of one
+// bucket could only tie with the darkest of the next if white and black fell
+// outside the first bucket, and being gray they have a hue of 0.
+func getHueThenLuma(c color.Color, buckets int) float64 {
+	bucketSize := 360 / float64(buckets)
+	return math.Floor(getHue(c)/bucketSize)*maxLuma + getLuma(c)
+}
+
+// Blends hue with the column of a pixel, alpha of 1 being pure hue and 0 being
+// pure position, which leaves spans as they are.
+func getSpatialHueWeight(c color.Color, x, width int, alpha float64) float64 {
+	return alpha*getHue(c)/360.0 + (1-alpha)*float64(x)/float64(width)
+}
+
+// Precomputes the sort keys of every span, stretched so that the smallest key
+// across all spans is 0 and the largest is 1.
+func normalizeSpansSortKeys(spans []ColorSpan, keyFn SortKeyFunc) []ColorSpan {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i, span := range spans {
+		spans[i].keys = make([]float64, len(span.pixels))
+		for j, c := range span.pixels {
+			k := keyFn(c, span.points[j])
+			spans[i].keys[j] = k
+			lo = math.Min(lo, k)
+			hi = math.Max(hi, k)
+		}
+	}
+
+	for _, span := range spans {
+		for j, k := range span.keys {
+			if hi > lo {
+				span.keys[j] = (k - lo) / (hi - lo)
+			} else {
+				span.keys[j] = 0
+			}
+		}
+	}
+
+	return spans
+}
+
+// CIE 1931 2° chromaticity coordinates of the spectral locus from 380nm to
+// 700nm in 5nm steps.
+var spectralLocus = [...][2]float64{
+	{0.1741, 0.0050}, {0.1740, 0.0050}, {0.1738, 0.0049}, {0.1736, 0.0049},
+	{0.1733, 0.0048}, {0.1730, 0.0048}, {0.1726, 0.0048}, {0.1721, 0.0048},
+	{0.1714, 0.0051}, {0.1703, 0.0058}, {0.1689, 0.0069}, {0.1669, 0.0086},
+	{0.1644, 0.0109}, {0.1611, 0.0138}, {0.1566, 0.0177}, {0.1510, 0.0227},
+	{0.1440, 0.0297}, {0.1355, 0.0399}, {0.1241, 0.0578}, {0.1096, 0.0868},
+	{0.0913, 0.1327}, {0.0687, 0.2007}, {0.0454, 0.2950}, {0.0235, 0.4127},
+	{0.0082, 0.5384}, {0.0039, 0.6548}, {0.0139, 0.7502}, {0.0389, 0.8120},
+	{0.0743, 0.8338}, {0.1142, 0.8262}, {0.1547, 0.8059}, {0.1929, 0.7816},
+	{0.2296, 0.7543}, {0.2658, 0.7243}, {0.3016, 0.6923}, {0.3373, 0.6589},
+	{0.3731, 0.6245}, {0.4087, 0.5896}, {0.4441, 0.5547}, {0.4788, 0.5202},
+	{0.5125, 0.4866}, {0.5448, 0.4544}, {0.5752, 0.4242}, {0.6029, 0.3965},
+	{0.6270, 0.3725}, {0.6482, 0.3514}, {0.6658, 0.3340}, {0.6801, 0.3197},
+	{0.6915, 0.3083}, {0.7006, 0.2993}, {0.7079, 0.2920}, {0.7140, 0.2859},
+	{0.7190, 0.2809}, {0.7230, 0.2770}, {0.7260, 0.2740}, {0.7283, 0.2717},
+	{0.7300, 0.2700}, {0.7311, 0.2689}, {0.7320, 0.2680}, {0.7327, 0.2673},
+	{0.7334, 0.2666}, {0.7340, 0.2660}, {0.7344, 0.2656}, {0.7346, 0.2654},
+	{0.7347, 0.2653},
+}
+
+const spectralLocusStart float64 = 380
+const spectralLocusStep float64 = 5
+
+// D65 white point chromaticity.
+const whiteX float64 = 0.3127
+const whiteY float64 = 0.3290
+
+// Finds where the ray from the white point in direction (dx, dy) crosses the
+// spectral locus, interpolating the wavelength between table entries.
+func intersectSpectralLocus(dx, dy float64) (float64, bool) {
+	for i := 0; i < len(spectralLocus)-1; i++ {
+		ax, ay := spectralLocus[i][0]-whiteX, spectralLocus[i][1]-whiteY
+		ex, ey := spectralLocus[i+1][0]-spectralLocus[i][0], spectralLocus[i+1][1]-spectralLocus[i][1]
+
+		denom := dx*ey - dy*ex
+		if denom == 0 {
+			continue
+		}
+		t := (ax*ey - ay*ex) / denom
+		u := (ax*dy - ay*dx) / denom
+		if t > 0 && u >= 0 && u <= 1 {
+			return spectralLocusStart + (float64(i)+u)*spectralLocusStep, true
+		}
+	}
+
+	return 0, false
+}
+
+// https://en.wikipedia.org/wiki/Dominant_wavelength
+// Purples have no dominant wavelength, so they get the negated wavelength of
+// their complementary color instead. Greys have a key of 0.
+func getDominantWavelength(c color.Color) float64 {
+	x, y, z := getXYZ(c)
+	sum := x + y + z
+	if sum == 0 {
+		return 0
+	}
+	dx, dy := x/sum-whiteX, y/sum-whiteY
+	if math.Abs(dx) < 1e-4 && math.Abs(dy) < 1e-4 {
+		return 0
+	}
+
+	if wavelength, ok := intersectSpectralLocus(dx, dy); ok {
+		return wavelength
+	}
+	if wavelength, ok := intersectSpectralLocus(-dx, -dy); ok {
+		return -wavelength
+	}
+	return 0
+}
+
+// The standard deviation of saturation in the square neighborhood of a pixel.
+func getLocalSaturationContrast(img image.Image, x, y, radius int) float64 {
+	b := img.Bounds()
+	var sum, sumSq, n float64
+	for ny := max(b.Min.Y, y-radius); ny <= min(b.Max.Y-1, y+radius); ny++ {
+		for nx := max(b.Min.X, x-radius); nx <= min(b.Max.X-1, x+radius); nx++ {
+			s := getSaturation(img.At(nx, ny))
+			sum += s
+			sumSq += s * s
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+
+	mean := sum / n
+	return math.Sqrt(math.Max(0, sumSq/n-mean*mean))
+}
+
+// The deflated size of the 3x3 neighborhood of a pixel. Smooth areas compress
+// well and get low keys while noisy areas get high keys.
+func getLocalCompressibility(img image.Image, x, y int) float64 {
+	b := img.Bounds()
+	var raw []byte
+	for ny := y - 1; ny <= y+1; ny++ {
+		for nx := x - 1; nx <= x+1; nx++ {
+			p := image.Pt(min(max(nx, b.Min.X), b.Max.X-1), min(max(ny, b.Min.Y), b.Max.Y-1))
+			r, g, bl, _ := img.At(p.X, p.Y).RGBA()
+			raw = append(raw, byte(r>>8), byte(g>>8), byte(bl>>8))
+		}
+	}
+
+	var buf bytes.Buffer
+	w := zlibWriters.Get().(*zlib.Writer)
+	defer zlibWriters.Put(w)
+	w.Reset(&buf)
+	w.Write(raw)
+	w.Close()
+	return float64(buf.Len())
+}
+
+// Compressors are expensive to allocate so they are reused between pixels. The
+// faster levels store inputs this small uncompressed, hiding any difference
+// between neighborhoods.
+var zlibWriters = sync.Pool{
+	New: func() any {
+		w, _ := zlib.NewWriterLevel(nil, zlib.BestCompression)
+		return w
+	},
+}
+
+// https://bottosson.github.io/posts/oklab/
+var linearSRGBToLMS = [3][3]float64{
+	{0.4122214708, 0.5363325363, 0.0514459929},
+	{0.2119034982, 0.6806995451, 0.1073969566},
+	{0.0883024619, 0.2817188376, 0.6299787005},
+}
+
+var lmsToOklab = [3][3]float64{
+	{0.2104542553, 0.7936177850, -0.0040720468},
+	{1.9779984951, -2.4285922050, 0.4505937099},
+	{0.0259040371, 0.7827717662, -0.8086757660},
+}
+
+func getOklab(c color.Color) (float64, float64, float64) {
+	r, g, b, _ := c.RGBA()
+	rgb := [3]float64{linearize(r), linearize(g), linearize(b)}
+
+	var lms [3]float64
+	for i := range 3 {
+		for j := range 3 {
+			lms[i] += linearSRGBToLMS[i][j] * rgb[j]
+		}
+		lms[i] = math.Cbrt(lms[i])
+	}
+
+	var lab [3]float64
+	for i := range 3 {
+		for j := range 3 {
+			lab[i] += lmsToOklab[i][j] * lms[j]
+		}
+	}
+
+	return lab[0], lab[1], lab[2]
+}
+
+// The Oklab perceived lightness, 0 for black and 1 for white.
+func getOklabLightness(c color.Color) float64 {
+	l, _, _ := getOklab(c)
+	return l
+}
+
+// The Oklch chroma, the distance of a color from grey of the same lightness.
+func getOklabChroma(c color.Color) float64 {
+	_, a, b := getOklab(c)
+	return math.Hypot(a, b)
+}
+
+// The Oklch hue angle in degrees.
+func getOklabHue(c color.Color) float64 {
+	_, a, b := getOklab(c)
+	hue := math.Atan2(b, a) * 180 / math.Pi
+	if hue < 0 {
+		hue += 360
+	}
+	return hue
+}
+
+// https://en.wikipedia.org/wiki/Z-order_curve
+// Interleaves the bits of the 16 bit channels, most significant first, into a
+// 48 bit code. The code fits exactly in a float64.
+func getMortonCode(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	var code uint64
+	for bit := 15; bit >= 0; bit-- {
+		code = code<<3 | uint64(r>>bit&1)<<2 | uint64(g>>bit&1)<<1 | uint64(b>>bit&1)
+	}
+	return float64(code)
+}
@@ -0,0 +1,210 @@
+package pixelsort
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+)
+
+type spanFileHeader struct {
+	SpanType string       `json:"spanType"`
+	Width    int          `json:"width"`
+	Height   int          `json:"height"`
+	Angle    float64      `json:"angle,omitempty"`
+	Center   *image.Point `json:"center,omitempty"`
+}
+
+type spanRecord struct {
+	ID       int  `json:"id"`
+	Idx      int  `json:"idx"`
+	Len      int  `json:"len"`
+	Reversed bool `json:"reversed,omitempty"`
+}
+
+type spanFile struct {
+	Header spanFileHeader `json:"header"`
+	Spans  []spanRecord   `json:"spans"`
+}
+
+// Writes spans found in an image of the given size as JSON, so the sort can
+// be inspected or replayed with ImportSpans. The span type, angle and radial
+// center are taken from opts and stored alongside the spans.
+func ExportSpans(filename string, spans []Span, opts Options, size image.Point) error {
+	header := spanFileHeader{SpanType: opts.SpanType.String(), Width: size.X, Height: size.Y}
+	switch opts.SpanType {
+	case Horizontal:
+		header.Angle = opts.Angle
+	case Vertical, Diagonal:
+	case Radial:
+		cx, cy := radialCenter(image.Rectangle{Max: size}, opts.Center.X, opts.Center.Y)
+		header.Center = &image.Point{cx, cy}
+	default:
+		return fmt.Errorf("spans can not be exported for span type: %s", opts.SpanType.String())
+	}
+
+	file := spanFile{
+		Header: header,
+		Spans:  make([]spanRecord, 0, len(spans)),
+	}
+	for _, span := range spans {
+		file.Spans = append(file.Spans, spanRecord{span.id, span.idx, span.len, span.reversed})
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// Reads spans written by ExportSpans into opts, along with the span type,
+// angle and radial center they were found with and the size of the image
+// they were found in.
+func ImportSpans(filename string, opts *Options) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var file spanFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return fmt.Errorf("invalid span file: %w", err)
+	}
+	var spanType SpanType
+	err = spanType.Set(file.Header.SpanType)
+	if err != nil {
+		return err
+	}
+
+	var spans []Span = make([]Span, 0, len(file.Spans))
+	for _, r := range file.Spans {
+		if r.ID < 0 || r.Idx < 0 || r.Len < 0 {
+			return errors.New("Imported spans must not have negative fields.")
+		}
+		spans = append(spans, Span{r.ID, r.Idx, r.Len, r.Reversed})
+	}
+
+	opts.ImportedSpans = spans
+	opts.ImportedSize = image.Pt(file.Header.Width, file.Header.Height)
+	opts.SpanType = spanType
+	opts.Angle = file.Header.Angle
+	if file.Header.Center != nil {
+		opts.Center = *file.Header.Center
+	}
+	return nil
+}
+
+// The number of pixels along each line spans of the imported span type lie
+// on in an image with bounds b, indexed by span id. Nil for span types that
+// can not be imported.
+func importedLineLengths(b image.Rectangle, opts Options, angled bool) []int {
+	var lengths []int
+	switch {
+	case angled:
+		for _, line := range angledScanLines(b, opts.Angle) {
+			lengths = append(lengths, len(line))
+		}
+	case opts.SpanType == Horizontal:
+		for range b.Dy() {
+			lengths = append(lengths, b.Dx())
+		}
+	case opts.SpanType == Vertical:
+		for range b.Dx() {
+			lengths = append(lengths, b.Dy())
+		}
+	case opts.SpanType == Diagonal:
+		for id := range b.Dx() + b.Dy() - 1 {
+			start := diagonalStart(id, b.Dy())
+			lengths = append(lengths, min(b.Dx()-start.X, b.Dy()-start.Y))
+		}
+	case opts.SpanType == Radial:
+		cx, cy := radialCenter(b, opts.Center.X, opts.Center.Y)
+		for _, ring := range radialRings(b, cx, cy) {
+			lengths = append(lengths, len(ring))
+		}
+	}
+	return lengths
+}
+
+// Makes sure every span lies within the line its id refers to.
+func checkImportedSpans(spans []Span, lengths []int) error {
+	for i, span := range spans {
+		if span.id >= len(lengths) || span.idx > lengths[span.id] || span.len > lengths[span.id]-span.idx {
+			return fmt.Errorf("imported span %d does not fit the image: id %d, idx %d, len %d", i, span.id, span.idx, span.len)
+		}
+	}
+	return nil
+}
+
+// Sorts the spans in opts.ImportedSpans instead of generating a mask and
+// spans from the image.
+func sortImportedSpans(ctx context.Context, img image.Image, opts Options) (image.Image, error) {
+	var cspans []ColorSpan
+	var err error
+	spans := opts.ImportedSpans
+	b := img.Bounds()
+	if opts.ImportedSize != b.Size() {
+		return nil, errors.New("Imported spans must come from an image of the same dimensions.")
+	}
+
+	angled := opts.SpanType == Horizontal && opts.Angle != 0
+	err = checkImportedSpans(spans, importedLineLengths(b, opts, angled))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case angled:
+		cspans = generateAngledColorSpans(img, spans, opts.Angle)
+	case opts.SpanType == Horizontal:
+		cspans = GenerateHorizontalColorSpans(img, spans)
+	case opts.SpanType == Vertical:
+		cspans = GenerateVerticalColorSpans(img, spans)
+	case opts.SpanType == Diagonal:
+		cspans = GenerateDiagonalColorSpans(img, spans)
+	case opts.SpanType == Radial:
+		cspans = generateRadialColorSpans(img, spans, opts.Center.X, opts.Center.Y)
+	default:
+		return nil, fmt.Errorf("spans can not be imported for span type: %s", opts.SpanType.String())
+	}
+
+	cspans, err = SortColorSpans(ctx, cspans, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var apply func(cspans []ColorSpan) (image.Image, error)
+	switch {
+	case angled:
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applyAngledSpans(ctx, img, cspans, opts.Angle)
+		}
+	case opts.SpanType == Horizontal:
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return ApplyHorizontalSpans(ctx, img, cspans)
+		}
+	case opts.SpanType == Vertical:
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return ApplyVerticalSpans(ctx, img, cspans)
+		}
+	case opts.SpanType == Diagonal:
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return ApplyDiagonalSpans(ctx, img, cspans)
+		}
+	default:
+		apply = func(cspans []ColorSpan) (image.Image, error) {
+			return applyRadialSpans(ctx, img, cspans, opts.Center.X, opts.Center.Y)
+		}
+	}
+	if opts.sorted != nil {
+		*opts.sorted = sortedSpans{len(cspans), func(n int) (image.Image, error) {
+			return apply(cspans[:n])
+		}}
+	}
+
+	return apply(cspans)
+}
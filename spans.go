@@ -0,0 +1,1671 @@
+package pixelsort
+
+import (
+	"container/heap"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+)
+
+// A span that follows an arbitrary path through the image rather than a
+// single row or column.
+type PixelSpan struct {
+	points []image.Point
+}
+
+// https://en.wikipedia.org/wiki/Bresenham%27s_line_algorithm
+func bresenhamLine(from image.Point, to image.Point) []image.Point {
+	dx := to.X - from.X
+	if dx < 0 {
+		dx = -dx
+	}
+	dy := to.Y - from.Y
+	if dy > 0 {
+		dy = -dy
+	}
+	sx, sy := 1, 1
+	if from.X > to.X {
+		sx = -1
+	}
+	if from.Y > to.Y {
+		sy = -1
+	}
+
+	var points []image.Point
+	x, y := from.X, from.Y
+	e := dx + dy
+	for {
+		points = append(points, image.Pt(x, y))
+		if x == to.X && y == to.Y {
+			break
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y += sy
+		}
+	}
+
+	return points
+}
+
+// Splits a path into the runs of consecutive white mask pixels along it.
+// Points outside the mask or already claimed by another span end a run, so a
+// pixel never belongs to more than one span.
+func generatePathSpans(mask image.Image, path []image.Point, claimed [][]bool, minSpanLen int) []PixelSpan {
+	var spans []PixelSpan = make([]PixelSpan, 0)
+	b := mask.Bounds()
+	var span PixelSpan
+
+	for _, p := range path {
+		inside := p.In(b) && !claimed[p.Y-b.Min.Y][p.X-b.Min.X]
+		if inside && mask.At(p.X, p.Y) == RGBAWhite {
+			span.points = append(span.points, p)
+			continue
+		}
+		if len(span.points) >= minSpanLen && len(span.points) > 0 {
+			spans = append(spans, span)
+			claim(claimed, b, span)
+		}
+		span = PixelSpan{}
+	}
+	if len(span.points) >= minSpanLen && len(span.points) > 0 {
+		spans = append(spans, span)
+		claim(claimed, b, span)
+	}
+
+	return spans
+}
+
+func newClaimGrid(b image.Rectangle) [][]bool {
+	claimed := make([][]bool, b.Dy())
+	for i := range claimed {
+		claimed[i] = make([]bool, b.Dx())
+	}
+	return claimed
+}
+
+func claim(claimed [][]bool, b image.Rectangle, span PixelSpan) {
+	for _, p := range span.points {
+		claimed[p.Y-b.Min.Y][p.X-b.Min.X] = true
+	}
+}
+
+// Generates spans along lines drawn from a single point on the top edge to
+// every pixel of the bottom edge.
+func generateFanSpans(ctx context.Context, mask image.Image, sourceX int, minSpanLen int) ([]PixelSpan, error) {
+	var spans []PixelSpan = make([]PixelSpan, 0)
+	b := mask.Bounds()
+	if b.Empty() {
+		return spans, nil
+	}
+	if sourceX < 0 || sourceX >= b.Dx() {
+		sourceX = b.Dx() / 2
+	}
+
+	claimed := newClaimGrid(b)
+	source := image.Pt(b.Min.X+sourceX, b.Min.Y)
+	for x := b.Min.X; x < b.Max.X; x++ {
+		if (x-b.Min.X)%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		line := bresenhamLine(source, image.Pt(x, b.Max.Y-1))
+		spans = append(spans, generatePathSpans(mask, line, claimed, minSpanLen)...)
+	}
+
+	return spans, nil
+}
+
+func generatePathColorSpans(img image.Image, spans []PixelSpan) []ColorSpan {
+	var cspans []ColorSpan = make([]ColorSpan, 0, len(spans))
+
+	for i, span := range spans {
+		c := make([]color.Color, len(span.points))
+		for j, p := range span.points {
+			c[j] = img.At(p.X, p.Y)
+		}
+		p := make([]image.Point, len(span.points))
+		copy(p, span.points)
+		cspans = append(cspans, ColorSpan{c, i, 0, p, nil, false})
+	}
+
+	return cspans
+}
+
+// Color spans generated from path spans use their id to refer back to the
+// path they were read from.
+func applyPathSpans(ctx context.Context, src image.Image, spans []PixelSpan, cspans []ColorSpan) (image.Image, error) {
+	b := src.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, out.Bounds(), src, b.Min, draw.Src)
+
+	for n, cspan := range cspans {
+		if n%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		points := spans[cspan.id].points
+		for i, c := range cspan.pixels {
+			if cspan.idx+i >= len(points) {
+				break
+			}
+			p := points[cspan.idx+i]
+			out.Set(p.X, p.Y, c)
+		}
+	}
+
+	return out, nil
+}
+
+// The standard deviation of perceived luminance in the square neighborhood of
+// each pixel, indexed as [y][x].
+func computeTextureMap(img image.Image, radius int) [][]float64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	luminance := luminanceMap(img)
+
+	texture := make([][]float64, h)
+	for y := range h {
+		texture[y] = make([]float64, w)
+		for x := range w {
+			var sum, sumSq, n float64
+			for ny := max(0, y-radius); ny <= min(h-1, y+radius); ny++ {
+				for nx := max(0, x-radius); nx <= min(w-1, x+radius); nx++ {
+					l := luminance[ny][nx]
+					sum += l
+					sumSq += l * l
+					n++
+				}
+			}
+			mean := sum / n
+			texture[y][x] = math.Sqrt(math.Max(0, sumSq/n-mean*mean))
+		}
+	}
+
+	return texture
+}
+
+// Moves the span boundary at column x of row y by up to maxShift pixels
+// towards the position with the least texture. Ties favor the smallest shift.
+func textureAwareSnapBoundary(mask image.Image, textureMap [][]float64, x, y, maxShift int) int {
+	w := mask.Bounds().Dx()
+	best := x
+	bestTexture := math.Inf(1)
+
+	for shift := 0; shift <= maxShift; shift++ {
+		for _, candidate := range []int{x - shift, x + shift} {
+			if candidate < 0 || candidate > w {
+				continue
+			}
+			t := textureMap[y][min(candidate, w-1)]
+			if t < bestTexture {
+				best = candidate
+				bestTexture = t
+			}
+		}
+	}
+
+	return best
+}
+
+// Horizontal spans whose ends are snapped to nearby low-texture positions so
+// that detailed regions aren't cut through mid-span.
+func generateTextureAwareSpans(ctx context.Context, mask image.Image, textureMap [][]float64, maxShift int, minSpanLen int) ([]Span, error) {
+	var spans []Span = make([]Span, 0)
+	w := mask.Bounds().Dx()
+	rows, err := GenerateHorizontalSpans(ctx, mask, 1, 0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapped spans must not grow into their neighbors on the same row.
+	lo := 0
+	for i, span := range rows {
+		if i > 0 && rows[i-1].id != span.id {
+			lo = 0
+		}
+		hi := w
+		if i < len(rows)-1 && rows[i+1].id == span.id {
+			hi = rows[i+1].idx
+		}
+
+		start := textureAwareSnapBoundary(mask, textureMap, span.idx, span.id, maxShift)
+		end := textureAwareSnapBoundary(mask, textureMap, span.idx+span.len, span.id, maxShift)
+		start = max(start, lo)
+		end = min(end, hi)
+
+		if end > start && end-start >= minSpanLen {
+			spans = append(spans, Span{span.id, start, end - start, false})
+			lo = end
+		} else {
+			lo = span.idx + span.len
+		}
+	}
+
+	return spans, nil
+}
+
+// Generates spans along concentric rings centered on the image, each ring a
+// scaled copy of the ellipse with semi-axes a and b rotated by angle degrees.
+// Rings are spaced one pixel apart along the longer axis and continue until
+// they cover the whole image.
+func generateEllipseSpans(ctx context.Context, mask image.Image, a, b, angle float64, minSpanLen int) ([]PixelSpan, error) {
+	var spans []PixelSpan = make([]PixelSpan, 0)
+	bounds := mask.Bounds()
+	if bounds.Empty() {
+		return spans, nil
+	}
+	if a <= 0 {
+		a = float64(bounds.Dx()) / 2
+	}
+	if b <= 0 {
+		b = float64(bounds.Dy()) / 2
+	}
+
+	// Axes of the innermost ring.
+	unitA := a / math.Max(a, b)
+	unitB := b / math.Max(a, b)
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	cx := float64(bounds.Min.X) + float64(bounds.Dx()-1)/2
+	cy := float64(bounds.Min.Y) + float64(bounds.Dy()-1)/2
+
+	rings := 0.0
+	for _, corner := range []image.Point{bounds.Min, {bounds.Max.X - 1, bounds.Min.Y}, {bounds.Min.X, bounds.Max.Y - 1}, bounds.Max.Sub(image.Pt(1, 1))} {
+		dx, dy := float64(corner.X)-cx, float64(corner.Y)-cy
+		u := dx*cos + dy*sin
+		v := -dx*sin + dy*cos
+		rings = math.Max(rings, math.Hypot(u/unitA, v/unitB))
+	}
+
+	claimed := newClaimGrid(bounds)
+	for k := 0; k <= int(math.Ceil(rings)); k++ {
+		if k%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		ringA, ringB := float64(k)*unitA, float64(k)*unitB
+		// Sample at roughly half pixel increments along the ring.
+		step := 0.5 / math.Max(math.Max(ringA, ringB), 0.5)
+
+		var ring []image.Point
+		for t := 0.0; t < 2*math.Pi; t += step {
+			u, v := ringA*math.Cos(t), ringB*math.Sin(t)
+			p := image.Pt(int(math.Round(cx+u*cos-v*sin)), int(math.Round(cy+u*sin+v*cos)))
+			if len(ring) == 0 || ring[len(ring)-1] != p {
+				ring = append(ring, p)
+			}
+		}
+		spans = append(spans, generatePathSpans(mask, ring, claimed, minSpanLen)...)
+	}
+
+	return spans, nil
+}
+
+// Copies the region r of img into a new image whose bounds start at the
+// origin.
+func cropImage(img image.Image, r image.Rectangle) image.Image {
+	out := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(out, out.Bounds(), img, r.Min, draw.Src)
+	return out
+}
+
+// Sorts each cell of a cellW by cellH grid independently, with horizontal or
+// vertical spans picked per cell by the grid cell direction mode. The mask
+// must already be set in opts.
+func sortGrid(ctx context.Context, src image.Image, cellW, cellH int, opts Options) (image.Image, error) {
+	b := src.Bounds()
+	out := image.NewRGBA(b)
+	rng := rand.New(rand.NewPCG(uint64(opts.Seed), 0))
+	var cells []image.Rectangle
+	var cellSpans []sortedSpans
+
+	for row, y := 0, b.Min.Y; y < b.Max.Y; row, y = row+1, y+cellH {
+		for col, x := 0, b.Min.X; x < b.Max.X; col, x = col+1, x+cellW {
+			cell := image.Rect(x, y, x+cellW, y+cellH).Intersect(b)
+
+			cellOpts := opts
+			cellOpts.Mask = cropImage(opts.Mask, cell)
+			// Cells are sorted as images of their own, so positions seen by
+			// the sort key need moving back into the full image.
+			if opts.Key != nil {
+				cellOpts.Key = func(c color.Color, p image.Point) float64 {
+					return opts.Key(c, p.Add(cell.Min))
+				}
+			}
+			switch opts.GridCellDirectionMode {
+			case "horizontal":
+				cellOpts.SpanType = Horizontal
+			case "vertical":
+				cellOpts.SpanType = Vertical
+			case "random":
+				cellOpts.SpanType = SpanType(rng.IntN(2))
+			default:
+				cellOpts.SpanType = SpanType((row + col) % 2)
+			}
+
+			var cellSorted sortedSpans
+			if opts.sorted != nil {
+				cellOpts.sorted = &cellSorted
+			}
+
+			sorted, err := SortImageContext(ctx, cropImage(src, cell), cellOpts)
+			if err != nil {
+				return nil, err
+			}
+			draw.Draw(out, cell, sorted, image.Point{}, draw.Src)
+			cells = append(cells, cell)
+			cellSpans = append(cellSpans, cellSorted)
+		}
+	}
+
+	// Like the span limit, applying n spans applies n in every cell.
+	if opts.sorted != nil {
+		count := 0
+		for _, cs := range cellSpans {
+			count = max(count, cs.count)
+		}
+		*opts.sorted = sortedSpans{count, func(n int) (image.Image, error) {
+			out := image.NewRGBA(b)
+			for i, cs := range cellSpans {
+				sorted, err := cs.apply(min(n, cs.count))
+				if err != nil {
+					return nil, err
+				}
+				draw.Draw(out, cells[i], sorted, image.Point{}, draw.Src)
+			}
+			return out, nil
+		}}
+	}
+
+	return out, nil
+}
+
+// Horizontal spans that stop at the edges of a grid of tileW by tileH tiles,
+// so each tile is sorted independently. A tile size of 0 spans the image.
+func generateTiledHorizontalSpans(ctx context.Context, mask image.Image, tileW, tileH, minSpanLen int) ([]Span, error) {
+	var spans []Span = make([]Span, 0)
+	b := mask.Bounds()
+	if tileW <= 0 {
+		tileW = b.Dx()
+	}
+	if tileH <= 0 {
+		tileH = b.Dy()
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y += tileH {
+		for x := b.Min.X; x < b.Max.X; x += tileW {
+			tile := image.Rect(x, y, x+tileW, y+tileH).Intersect(b)
+			rows, err := GenerateHorizontalSpans(ctx, cropImage(mask, tile), minSpanLen, 0, false)
+			if err != nil {
+				return nil, err
+			}
+			for _, span := range rows {
+				span.id += tile.Min.Y - b.Min.Y
+				span.idx += tile.Min.X - b.Min.X
+				spans = append(spans, span)
+			}
+		}
+	}
+
+	return spans, nil
+}
+
+// Generates spans along the line from start to end. With a positive spacing
+// the line is repeated at that distance on both sides until the copies leave
+// the image. Negative end coordinates default to the far edges.
+func generateBresenhamLineSpans(ctx context.Context, mask image.Image, start, end image.Point, spacing int, minSpanLen int) ([]PixelSpan, error) {
+	var spans []PixelSpan = make([]PixelSpan, 0)
+	b := mask.Bounds()
+	if b.Empty() {
+		return spans, nil
+	}
+	if end.X < 0 {
+		end.X = b.Dx() - 1
+	}
+	if end.Y < 0 {
+		end.Y = b.Dy() - 1
+	}
+	start = start.Add(b.Min)
+	end = end.Add(b.Min)
+
+	claimed := newClaimGrid(b)
+	spans = append(spans, generatePathSpans(mask, bresenhamLine(start, end), claimed, minSpanLen)...)
+	if spacing < 1 {
+		return spans, nil
+	}
+
+	dx, dy := float64(end.X-start.X), float64(end.Y-start.Y)
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return spans, nil
+	}
+	nx, ny := -dy/length, dx/length
+	// Far enough to move a copy of the line past any corner of the image.
+	copies := int(math.Ceil(math.Hypot(float64(b.Dx()), float64(b.Dy()))/float64(spacing))) + 1
+
+	for k := 1; k <= copies; k++ {
+		if k%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for _, side := range []float64{1, -1} {
+			offset := side * float64(k*spacing)
+			shift := image.Pt(int(math.Round(nx*offset)), int(math.Round(ny*offset)))
+			line := bresenhamLine(start.Add(shift), end.Add(shift))
+			spans = append(spans, generatePathSpans(mask, line, claimed, minSpanLen)...)
+		}
+	}
+
+	return spans, nil
+}
+
+// Cyclically shifts the pixels of a span n positions towards its end.
+func rotateSpan(span ColorSpan, n int) ColorSpan {
+	length := len(span.pixels)
+	if length == 0 {
+		return span
+	}
+	n = ((n % length) + length) % length
+
+	pixels := make([]color.Color, length)
+	points := make([]image.Point, length)
+	for i := range length {
+		pixels[(i+n)%length] = span.pixels[i]
+		points[(i+n)%length] = span.points[i]
+	}
+
+	return ColorSpan{pixels, span.id, span.idx, points, nil, span.reversed}
+}
+
+// Span transforms that can be selected in place of a sort key.
+var SpanTransforms = map[string]func(ColorSpan) ColorSpan{
+	"run-length-encode":  runLengthGroupSpan,
+	"gradient-sign":      gradientSignAdaptiveSort,
+	"mirror-second-half": mirrorSecondHalf,
+}
+
+// What is done with the pixels of each span in place of sorting them, sort
+// leaving them to the sort key.
+var SpanModes = map[string]func(ColorSpan) ColorSpan{
+	"sort":     nil,
+	"average":  averageSpan,
+	"gradient": gradientSpan,
+}
+
+// Fills a span with the mean color of its pixels.
+func averageSpan(span ColorSpan) ColorSpan {
+	if len(span.pixels) == 0 {
+		return span
+	}
+
+	var sum [4]uint64
+	for _, c := range span.pixels {
+		r, g, b, a := c.RGBA()
+		sum[0] += uint64(r)
+		sum[1] += uint64(g)
+		sum[2] += uint64(b)
+		sum[3] += uint64(a)
+	}
+	n := uint64(len(span.pixels))
+	mean := color.RGBA64{uint16(sum[0] / n), uint16(sum[1] / n), uint16(sum[2] / n), uint16(sum[3] / n)}
+
+	pixels := make([]color.Color, len(span.pixels))
+	for i := range pixels {
+		pixels[i] = mean
+	}
+
+	return ColorSpan{pixels, span.id, span.idx, span.points, nil, span.reversed}
+}
+
+// Fills a span with a linear gradient from its first pixel's color to its
+// last's.
+func gradientSpan(span ColorSpan) ColorSpan {
+	n := len(span.pixels)
+	if n < 2 {
+		return span
+	}
+
+	r0, g0, b0, a0 := span.pixels[0].RGBA()
+	r1, g1, b1, a1 := span.pixels[n-1].RGBA()
+	lerp := func(from, to uint32, t float64) uint16 {
+		return uint16(math.Round(float64(from) + t*(float64(to)-float64(from))))
+	}
+
+	pixels := make([]color.Color, n)
+	for i := range pixels {
+		t := float64(i) / float64(n-1)
+		pixels[i] = color.RGBA64{lerp(r0, r1, t), lerp(g0, g1, t), lerp(b0, b1, t), lerp(a0, a1, t)}
+	}
+
+	return ColorSpan{pixels, span.id, span.idx, span.points, nil, span.reversed}
+}
+
+// Mirrors the hue of a color around the red axis of the color wheel, keeping
+// its saturation and value.
+func invertHue(c color.Color) color.Color {
+	return withHue(c, 360-getHue(c))
+}
+
+// Gives a color the hue in degrees, keeping its saturation and value. Greys
+// have no hue and are returned unchanged.
+func withHue(c color.Color, hue float64) color.Color {
+	r, g, b, a := c.RGBA()
+	hi := math.Max(math.Max(float64(r), float64(g)), float64(b))
+	lo := math.Min(math.Min(float64(r), float64(g)), float64(b))
+	if hi == lo {
+		return c
+	}
+
+	h := math.Mod(math.Mod(hue, 360)+360, 360) / 60
+	chroma := hi - lo
+	x := chroma * (1 - math.Abs(math.Mod(h, 2)-1))
+	var rgb [3]float64
+	switch int(h) {
+	case 0:
+		rgb = [3]float64{chroma, x, 0}
+	case 1:
+		rgb = [3]float64{x, chroma, 0}
+	case 2:
+		rgb = [3]float64{0, chroma, x}
+	case 3:
+		rgb = [3]float64{0, x, chroma}
+	case 4:
+		rgb = [3]float64{x, 0, chroma}
+	default:
+		rgb = [3]float64{chroma, 0, x}
+	}
+
+	return color.RGBA64{
+		uint16(math.Round(rgb[0] + lo)),
+		uint16(math.Round(rgb[1] + lo)),
+		uint16(math.Round(rgb[2] + lo)),
+		uint16(a),
+	}
+}
+
+func applyHueInversion(span ColorSpan) ColorSpan {
+	for i, c := range span.pixels {
+		span.pixels[i] = invertHue(c)
+	}
+
+	return span
+}
+
+// Inserts c into the middle of a span, growing it by one pixel. Pixels after
+// the midpoint move one place along, so the last one lands on the pixel just
+// past the span, or off the line when the span reaches its end.
+func insertMidpointColor(span ColorSpan, c color.Color) ColorSpan {
+	n := len(span.pixels)
+	if n == 0 {
+		return span
+	}
+
+	mid := n / 2
+	span.pixels = slices.Insert(span.pixels, mid, c)
+	span.points = slices.Insert(span.points, mid, span.points[mid])
+	if span.keys != nil {
+		span.keys = slices.Insert(span.keys, mid, span.keys[mid])
+	}
+	return span
+}
+
+// Gathers pixels of identical hue into contiguous blocks, ordered by where
+// each hue first appears in the span, and paints each block with its
+// representative: the first pixel of that hue in the span.
+func runLengthGroupSpan(span ColorSpan) ColorSpan {
+	var order []float64
+	groups := make(map[float64][]int)
+	for i, c := range span.pixels {
+		h := getHue(c)
+		if _, ok := groups[h]; !ok {
+			order = append(order, h)
+		}
+		groups[h] = append(groups[h], i)
+	}
+
+	pixels := make([]color.Color, 0, len(span.pixels))
+	points := make([]image.Point, 0, len(span.points))
+	for _, h := range order {
+		representative := span.pixels[groups[h][0]]
+		for _, i := range groups[h] {
+			pixels = append(pixels, representative)
+			points = append(points, span.points[i])
+		}
+	}
+
+	return ColorSpan{pixels, span.id, span.idx, points, nil, span.reversed}
+}
+
+// Swaps amount times as many randomly picked adjacent pairs of pixels as
+// there are pairs, leaving them partially sorted, or Fisher-Yates shuffles
+// them completely when amount is 1. The pixels are shuffled in place.
+func partialShuffle(pixels []color.Color, amount float64, rng *rand.Rand) []color.Color {
+	n := len(pixels)
+	if amount < 1 {
+		for range int(amount * float64(n-1)) {
+			i := rng.IntN(n - 1)
+			pixels[i], pixels[i+1] = pixels[i+1], pixels[i]
+		}
+		return pixels
+	}
+
+	for i := n - 1; i > 0; i-- {
+		j := rng.IntN(i + 1)
+		pixels[i], pixels[j] = pixels[j], pixels[i]
+	}
+	return pixels
+}
+
+// A pixel that remembers where in its span it started, so a shuffle of the
+// pixels alone can be repeated on the points and keys of the span.
+type indexedColor struct {
+	color.Color
+	i int
+}
+
+// Fisher-Yates shuffles the first fraction of a span's pixels, leaving the
+// rest in place. With adjacent, it instead partially shuffles the whole span
+// with partialShuffle. A fraction of 1 shuffles the pixels completely either
+// way. Points and keys are moved along with their pixels.
+func partialShuffleSpan(span ColorSpan, fraction float64, adjacent bool, rng *rand.Rand) ColorSpan {
+	n := len(span.pixels)
+	if adjacent {
+		tagged := make([]color.Color, n)
+		for i, c := range span.pixels {
+			tagged[i] = indexedColor{c, i}
+		}
+		points := slices.Clone(span.points)
+		keys := slices.Clone(span.keys)
+		for i, c := range partialShuffle(tagged, fraction, rng) {
+			from := c.(indexedColor).i
+			span.pixels[i] = c.(indexedColor).Color
+			span.points[i] = points[from]
+			if keys != nil {
+				span.keys[i] = keys[from]
+			}
+		}
+		return span
+	}
+
+	for i := int(fraction*float64(n)) - 1; i > 0; i-- {
+		j := rng.IntN(i + 1)
+		span.pixels[i], span.pixels[j] = span.pixels[j], span.pixels[i]
+		span.points[i], span.points[j] = span.points[j], span.points[i]
+		if span.keys != nil {
+			span.keys[i], span.keys[j] = span.keys[j], span.keys[i]
+		}
+	}
+	return span
+}
+
+type floodItem struct {
+	p     image.Point
+	level float64
+}
+
+type floodQueue []floodItem
+
+func (q floodQueue) Len() int           { return len(q) }
+func (q floodQueue) Less(i, j int) bool { return q[i].level < q[j].level }
+func (q floodQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *floodQueue) Push(x any)        { *q = append(*q, x.(floodItem)) }
+func (q *floodQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// Segments the luminance gradient of an image with Meyer's flooding algorithm
+// and returns which pixels lie on the watershed lines between segments,
+// indexed as [y][x]. Markers are the connected flat areas in the lowest fifth
+// of gradient magnitudes.
+// https://en.wikipedia.org/wiki/Watershed_(image_processing)
+func watershedBoundaries(ctx context.Context, img image.Image) ([][]bool, error) {
+	gx, gy := sobel(gaussianBlur(luminanceMap(img), 2))
+	h := len(gx)
+	if h == 0 {
+		return nil, nil
+	}
+	w := len(gx[0])
+
+	gradient := make([][]float64, h)
+	var levels []float64
+	for y := range h {
+		gradient[y] = make([]float64, w)
+		for x := range w {
+			gradient[y][x] = math.Hypot(gx[y][x], gy[y][x])
+			levels = append(levels, gradient[y][x])
+		}
+	}
+	sort.Float64s(levels)
+	flat := levels[len(levels)/5]
+
+	const unlabeled, boundary = 0, -1
+	labels := make([][]int, h)
+	for y := range h {
+		labels[y] = make([]int, w)
+	}
+	neighbors := []image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	inside := func(p image.Point) bool {
+		return p.X >= 0 && p.Y >= 0 && p.X < w && p.Y < h
+	}
+
+	next := 1
+	for y := range h {
+		if y%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for x := range w {
+			if labels[y][x] != unlabeled || gradient[y][x] > flat {
+				continue
+			}
+			stack := []image.Point{{x, y}}
+			labels[y][x] = next
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				for _, d := range neighbors {
+					n := p.Add(d)
+					if inside(n) && labels[n.Y][n.X] == unlabeled && gradient[n.Y][n.X] <= flat {
+						labels[n.Y][n.X] = next
+						stack = append(stack, n)
+					}
+				}
+			}
+			next++
+		}
+	}
+
+	queued := make([][]bool, h)
+	for y := range h {
+		queued[y] = make([]bool, w)
+	}
+	queue := &floodQueue{}
+	for y := range h {
+		for x := range w {
+			if labels[y][x] == unlabeled {
+				continue
+			}
+			for _, d := range neighbors {
+				n := image.Pt(x, y).Add(d)
+				if inside(n) && labels[n.Y][n.X] == unlabeled && !queued[n.Y][n.X] {
+					queued[n.Y][n.X] = true
+					heap.Push(queue, floodItem{n, gradient[n.Y][n.X]})
+				}
+			}
+		}
+	}
+
+	for n := 0; queue.Len() > 0; n++ {
+		if n%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		p := heap.Pop(queue).(floodItem).p
+		label := unlabeled
+		for _, d := range neighbors {
+			n := p.Add(d)
+			if !inside(n) || labels[n.Y][n.X] <= unlabeled {
+				continue
+			}
+			if label == unlabeled {
+				label = labels[n.Y][n.X]
+			} else if label != labels[n.Y][n.X] {
+				label = boundary
+				break
+			}
+		}
+		labels[p.Y][p.X] = label
+		if label == boundary {
+			continue
+		}
+
+		for _, d := range neighbors {
+			n := p.Add(d)
+			if inside(n) && labels[n.Y][n.X] == unlabeled && !queued[n.Y][n.X] {
+				queued[n.Y][n.X] = true
+				heap.Push(queue, floodItem{n, gradient[n.Y][n.X]})
+			}
+		}
+	}
+
+	boundaries := make([][]bool, h)
+	for y := range h {
+		boundaries[y] = make([]bool, w)
+		for x := range w {
+			boundaries[y][x] = labels[y][x] == boundary
+		}
+	}
+
+	return boundaries, nil
+}
+
+// Generates spans by walking the watershed lines between segments of the
+// image, following each line through its 8-connected neighbors.
+func generateWatershedBoundarySpans(ctx context.Context, img, mask image.Image, minLen int) ([]PixelSpan, error) {
+	var spans []PixelSpan = make([]PixelSpan, 0)
+	b := mask.Bounds()
+	boundaries, err := watershedBoundaries(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	claimed := newClaimGrid(b)
+	neighbors := []image.Point{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+
+	for y := range boundaries {
+		if y%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for x := range boundaries[y] {
+			if !boundaries[y][x] {
+				continue
+			}
+
+			var contour []image.Point
+			p := image.Pt(x, y)
+			for {
+				boundaries[p.Y][p.X] = false
+				contour = append(contour, p.Add(b.Min))
+
+				found := false
+				for _, d := range neighbors {
+					n := p.Add(d)
+					if n.X >= 0 && n.Y >= 0 && n.Y < len(boundaries) && n.X < len(boundaries[n.Y]) && boundaries[n.Y][n.X] {
+						p = n
+						found = true
+						break
+					}
+				}
+				if !found {
+					break
+				}
+			}
+
+			spans = append(spans, generatePathSpans(mask, contour, claimed, minLen)...)
+		}
+	}
+
+	return spans, nil
+}
+
+// Sorts a span by luminance in the direction it already trends, so spans
+// getting brighter along their length sort ascending and the rest descending.
+func gradientSignAdaptiveSort(span ColorSpan) ColorSpan {
+	n := len(span.pixels)
+	if n < 2 {
+		return span
+	}
+
+	keys := make([]float64, n)
+	var first, second float64
+	for i, c := range span.pixels {
+		keys[i] = getLuma(c)
+		if i < n/2 {
+			first += keys[i]
+		} else {
+			second += keys[i]
+		}
+	}
+	rising := second/float64(n-n/2) >= first/float64(n/2)
+
+	sort.Sort(spanSorter{span, keys, rising})
+	span.keys = nil
+	return span
+}
+
+// Generates spans along diamond shaped rings around (cx, cy), the pixels of
+// each ring sharing the same Manhattan distance from the center. Negative
+// center coordinates default to the image center.
+func generateDiamondSpans(ctx context.Context, mask image.Image, cx, cy, minLen int) ([]PixelSpan, error) {
+	var spans []PixelSpan = make([]PixelSpan, 0)
+	b := mask.Bounds()
+	if b.Empty() {
+		return spans, nil
+	}
+	if cx < 0 {
+		cx = b.Dx() / 2
+	}
+	if cy < 0 {
+		cy = b.Dy() / 2
+	}
+	center := b.Min.Add(image.Pt(cx, cy))
+
+	rings := 0
+	for _, corner := range []image.Point{b.Min, {b.Max.X - 1, b.Min.Y}, {b.Min.X, b.Max.Y - 1}, b.Max.Sub(image.Pt(1, 1))} {
+		d := corner.Sub(center)
+		rings = max(rings, abs(d.X)+abs(d.Y))
+	}
+
+	claimed := newClaimGrid(b)
+	// Walk each ring counterclockwise in screen space starting from its
+	// rightmost point.
+	directions := []image.Point{{-1, 1}, {-1, -1}, {1, -1}, {1, 1}}
+	for d := 0; d <= rings; d++ {
+		if d%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		ring := []image.Point{center.Add(image.Pt(d, 0))}
+		for _, dir := range directions {
+			for range d {
+				ring = append(ring, ring[len(ring)-1].Add(dir))
+			}
+		}
+		// The walk ends back where it started.
+		if d > 0 {
+			ring = ring[:len(ring)-1]
+		}
+		spans = append(spans, generatePathSpans(mask, ring, claimed, minLen)...)
+	}
+
+	return spans, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Overwrites the first half of a span with the second half reversed, making
+// the span a palindrome around its midpoint.
+func mirrorSecondHalf(span ColorSpan) ColorSpan {
+	n := len(span.pixels)
+	for i := range n / 2 {
+		span.pixels[i] = span.pixels[n-1-i]
+		span.points[i] = span.points[n-1-i]
+	}
+
+	return span
+}
+
+// Sorts the red, green, and blue channels of a span each on their own, so
+// pixels are rebuilt from channels that came from different pixels. Like
+// SortSpans, channels run from high to low unless reversed.
+func sortChannelsIndependently(span ColorSpan, reverse bool) ColorSpan {
+	n := len(span.pixels)
+	channels := [3][]uint8{make([]uint8, n), make([]uint8, n), make([]uint8, n)}
+	alphas := make([]uint8, n)
+	for i, c := range span.pixels {
+		r, g, b, a := c.RGBA()
+		channels[0][i], channels[1][i], channels[2][i] = uint8(r>>8), uint8(g>>8), uint8(b>>8)
+		alphas[i] = uint8(a >> 8)
+	}
+
+	for _, channel := range channels {
+		sort.Slice(channel, func(i, j int) bool {
+			if reverse {
+				return channel[i] < channel[j]
+			}
+			return channel[i] > channel[j]
+		})
+	}
+
+	// Alpha stays with its position, and the premultiplied channels are kept
+	// within it.
+	pixels := make([]color.Color, n)
+	for i := range n {
+		a := alphas[i]
+		pixels[i] = color.RGBA{min(channels[0][i], a), min(channels[1][i], a), min(channels[2][i], a), a}
+	}
+	span.pixels = pixels
+	span.keys = nil
+
+	return span
+}
+
+// Reverses a span when the average hue of its first half is greater than that
+// of its second half, so the hues of every span ascend in the same direction.
+func spectralNormalizeSpan(span ColorSpan) ColorSpan {
+	n := len(span.pixels)
+	if n < 2 {
+		return span
+	}
+
+	var first, second float64
+	for i, c := range span.pixels {
+		if i < n/2 {
+			first += getHue(c)
+		} else if i >= n-n/2 {
+			second += getHue(c)
+		}
+	}
+	if first <= second {
+		return span
+	}
+
+	for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+		span.pixels[i], span.pixels[j] = span.pixels[j], span.pixels[i]
+		span.points[i], span.points[j] = span.points[j], span.points[i]
+		if span.keys != nil {
+			span.keys[i], span.keys[j] = span.keys[j], span.keys[i]
+		}
+	}
+	return span
+}
+
+// Generates spans along minimum cost paths from the left edge to the right
+// edge of the image, starting from numPaths evenly spaced rows. Crossing an
+// edge is expensive, so the paths bend around the objects in the image
+// rather than cutting through them. Paths only step rightwards, one column
+// at a time.
+//
+// A pixel costs 1 + edgeStrength rather than 1/(1 + edgeStrength). The
+// inverse would make edges the cheapest pixels to cross, drawing the paths
+// along edges instead of around them.
+func generateGeodesicSpans(ctx context.Context, img, mask image.Image, numPaths, minLen int) ([]PixelSpan, error) {
+	var spans []PixelSpan = make([]PixelSpan, 0)
+	b := mask.Bounds()
+	if b.Empty() || numPaths < 1 {
+		return spans, nil
+	}
+
+	luminance := luminanceMap(img)
+	for y := range luminance {
+		for x := range luminance[y] {
+			luminance[y][x] /= 65535
+		}
+	}
+	gx, gy := sobel(luminance)
+	w, h := b.Dx(), b.Dy()
+	cost := make([][]float64, h)
+	for y := range h {
+		cost[y] = make([]float64, w)
+		for x := range w {
+			cost[y][x] = 1 + math.Hypot(gx[y][x], gy[y][x])
+		}
+	}
+
+	claimed := newClaimGrid(b)
+	dist := make([][]float64, h)
+	prev := make([][]int, h)
+	for y := range h {
+		dist[y] = make([]float64, w)
+		prev[y] = make([]int, w)
+	}
+
+	for i := range numPaths {
+		start := image.Pt(0, (2*i+1)*h/(2*numPaths))
+		for y := range h {
+			for x := range w {
+				dist[y][x] = math.Inf(1)
+			}
+		}
+		dist[start.Y][start.X] = cost[start.Y][start.X]
+
+		queue := &floodQueue{{start, dist[start.Y][start.X]}}
+		end := image.Pt(-1, -1)
+		for n := 0; queue.Len() > 0; n++ {
+			if n%cancelCheckInterval == 0 && ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			item := heap.Pop(queue).(floodItem)
+			p := item.p
+			if item.level > dist[p.Y][p.X] {
+				continue
+			}
+			if p.X == w-1 {
+				end = p
+				break
+			}
+			for dy := -1; dy <= 1; dy++ {
+				n := image.Pt(p.X+1, p.Y+dy)
+				if n.Y < 0 || n.Y >= h {
+					continue
+				}
+				if d := dist[p.Y][p.X] + cost[n.Y][n.X]; d < dist[n.Y][n.X] {
+					dist[n.Y][n.X] = d
+					prev[n.Y][n.X] = p.Y
+					heap.Push(queue, floodItem{n, d})
+				}
+			}
+		}
+
+		path := make([]image.Point, w)
+		for x, y := end.X, end.Y; x >= 0; x-- {
+			path[x] = b.Min.Add(image.Pt(x, y))
+			y = prev[y][x]
+		}
+		spans = append(spans, generatePathSpans(mask, path, claimed, minLen)...)
+	}
+
+	return spans, nil
+}
+
+// Splits r into its four quadrants in Z-order, leaving out empty quadrants
+// of cells that are a single pixel wide or tall.
+func quadrants(r image.Rectangle) []image.Rectangle {
+	mid := image.Pt(r.Min.X+r.Dx()/2, r.Min.Y+r.Dy()/2)
+	var quads []image.Rectangle
+	for _, q := range []image.Rectangle{
+		{r.Min, mid},
+		{image.Pt(mid.X, r.Min.Y), image.Pt(r.Max.X, mid.Y)},
+		{image.Pt(r.Min.X, mid.Y), image.Pt(mid.X, r.Max.Y)},
+		{mid, r.Max},
+	} {
+		if !q.Empty() {
+			quads = append(quads, q)
+		}
+	}
+
+	return quads
+}
+
+// Appends the pixels of r to path in Z-order.
+func zOrderPath(r image.Rectangle, path []image.Point) []image.Point {
+	if r.Dx() == 1 && r.Dy() == 1 {
+		return append(path, r.Min)
+	}
+	for _, q := range quadrants(r) {
+		path = zOrderPath(q, path)
+	}
+
+	return path
+}
+
+// Generates a span for each leaf of a quad-tree that splits the image until
+// the luminance variance of every cell is at most threshold or maxDepth is
+// reached. The pixels of each leaf are walked in Z-order, so flat areas are
+// sorted as large blocks and detailed areas as many small ones.
+func generateQuadTreeSpans(ctx context.Context, img, mask image.Image, maxDepth int, threshold float64, minLen int) ([]PixelSpan, error) {
+	var spans []PixelSpan = make([]PixelSpan, 0)
+	b := mask.Bounds()
+	luminance := luminanceMap(img)
+	claimed := newClaimGrid(b)
+
+	visited := 0
+	var visit func(r image.Rectangle, depth int) error
+	visit = func(r image.Rectangle, depth int) error {
+		if visited%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		visited++
+		var sum, sumSq float64
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				l := luminance[y-b.Min.Y][x-b.Min.X] / 65535
+				sum += l
+				sumSq += l * l
+			}
+		}
+		n := float64(r.Dx() * r.Dy())
+		mean := sum / n
+		variance := sumSq/n - mean*mean
+
+		if depth >= maxDepth || variance <= threshold || (r.Dx() == 1 && r.Dy() == 1) {
+			path := zOrderPath(r, nil)
+			spans = append(spans, generatePathSpans(mask, path, claimed, minLen)...)
+			return nil
+		}
+		for _, q := range quadrants(r) {
+			err := visit(q, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !b.Empty() {
+		err := visit(b, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return spans, nil
+}
+
+// Finds the rows likely to contain text with a projection profile: rows whose
+// average luminance strays more than deviation standard deviations from the
+// average of all rows are taken to cross a line of text.
+func findTextLineRows(img image.Image, deviation float64) []bool {
+	luminance := luminanceMap(img)
+	profile := make([]float64, len(luminance))
+	var sum, sumSq float64
+	for y, row := range luminance {
+		for _, l := range row {
+			profile[y] += l
+		}
+		profile[y] /= float64(len(row))
+		sum += profile[y]
+		sumSq += profile[y] * profile[y]
+	}
+
+	rows := make([]bool, len(profile))
+	if len(profile) == 0 {
+		return rows
+	}
+	mean := sum / float64(len(profile))
+	stddev := math.Sqrt(math.Max(0, sumSq/float64(len(profile))-mean*mean))
+	for y, p := range profile {
+		rows[y] = math.Abs(p-mean) > deviation*stddev
+	}
+
+	return rows
+}
+
+// Generates horizontal spans only on the rows that cross lines of text.
+func generateTextLineSpans(ctx context.Context, img, mask image.Image, deviation float64, minSpanLen, maxSpanLen int) ([]Span, error) {
+	var spans []Span = make([]Span, 0)
+	rows := findTextLineRows(img, deviation)
+
+	candidates, err := GenerateHorizontalSpans(ctx, mask, minSpanLen, maxSpanLen, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, span := range candidates {
+		if rows[span.id] {
+			spans = append(spans, span)
+		}
+	}
+
+	return spans, nil
+}
+
+// Rasterizes parallel lines at angleDeg degrees clockwise from horizontal
+// that together cover every pixel of the bounds exactly once. As in
+// Bresenham's algorithm each line steps one pixel at a time along its major
+// axis, one line starting from every pixel of the perpendicular edge and
+// beyond so the lines reach into the corners.
+func angledScanLines(b image.Rectangle, angleDeg float64) [][]image.Point {
+	var lines [][]image.Point
+	if b.Empty() {
+		return lines
+	}
+
+	angle := math.Mod(angleDeg, 360) * math.Pi / 180
+	dx, dy := math.Cos(angle), math.Sin(angle)
+
+	// Work as if the lines were mostly horizontal, swapping the axes back
+	// when they are mostly vertical.
+	steep := math.Abs(dy) > math.Abs(dx)
+	major, minor := b.Dx(), b.Dy()
+	slope, forward := dy/dx, dx > 0
+	if steep {
+		major, minor = minor, major
+		slope, forward = dx/dy, dy > 0
+	}
+
+	offsets := make([]int, major)
+	lo, hi := 0, 0
+	for i := range major {
+		offsets[i] = int(math.Round(float64(i) * slope))
+		lo, hi = min(lo, offsets[i]), max(hi, offsets[i])
+	}
+
+	for k := -hi; k < minor-lo; k++ {
+		var line []image.Point
+		for step := range major {
+			i := step
+			if !forward {
+				i = major - 1 - step
+			}
+			j := k + offsets[i]
+			if j < 0 || j >= minor {
+				continue
+			}
+			if steep {
+				line = append(line, image.Pt(b.Min.X+j, b.Min.Y+i))
+			} else {
+				line = append(line, image.Pt(b.Min.X+i, b.Min.Y+j))
+			}
+		}
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// Spans along lines at any angle, angleDeg being measured clockwise from
+// horizontal. Each span's id is the line it lies on and idx is its position
+// along the line.
+func generateAngledSpans(ctx context.Context, mask image.Image, angleDeg float64, minSpanLen int) ([]Span, error) {
+	var spans []Span = make([]Span, 0)
+
+	for id, line := range angledScanLines(mask.Bounds(), angleDeg) {
+		if id%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var span Span = Span{id, 0, 0, false}
+		for i, p := range line {
+			if mask.At(p.X, p.Y) == RGBAWhite {
+				if span.len == 0 {
+					span.idx = i
+				}
+				span.len++
+				continue
+			}
+			if span.len >= minSpanLen && span.len > 0 {
+				spans = append(spans, span)
+			}
+			span.len = 0
+		}
+		if span.len >= minSpanLen && span.len > 0 {
+			spans = append(spans, span)
+		}
+	}
+
+	return spans, nil
+}
+
+func generateAngledColorSpans(img image.Image, spans []Span, angleDeg float64) []ColorSpan {
+	var cspans []ColorSpan = make([]ColorSpan, 0, len(spans))
+	lines := angledScanLines(img.Bounds(), angleDeg)
+
+	for _, span := range spans {
+		c := make([]color.Color, span.len)
+		p := make([]image.Point, span.len)
+		for i := range span.len {
+			p[i] = lines[span.id][span.idx+i]
+			c[i] = img.At(p[i].X, p[i].Y)
+		}
+		cspans = append(cspans, ColorSpan{c, span.id, span.idx, p, nil, false})
+	}
+
+	return cspans
+}
+
+func applyAngledSpans(ctx context.Context, src image.Image, spans []ColorSpan, angleDeg float64) (image.Image, error) {
+	b := src.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, out.Bounds(), src, b.Min, draw.Src)
+	lines := angledScanLines(b, angleDeg)
+
+	for n, span := range spans {
+		if n%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for i, c := range span.pixels {
+			if span.idx+i >= len(lines[span.id]) {
+				break
+			}
+			p := lines[span.id][span.idx+i]
+			out.Set(p.X, p.Y, c)
+		}
+	}
+
+	return out, nil
+}
+
+// Groups the pixels of the bounds into rings of equal rounded distance from
+// (cx, cy), indexed by radius. Each ring is ordered by angle around the
+// center.
+func radialRings(b image.Rectangle, cx, cy int) [][]image.Point {
+	var rings [][]image.Point
+	center := b.Min.Add(image.Pt(cx, cy))
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r := int(math.Round(math.Hypot(float64(x-center.X), float64(y-center.Y))))
+			for len(rings) <= r {
+				rings = append(rings, nil)
+			}
+			rings[r] = append(rings[r], image.Pt(x, y))
+		}
+	}
+	for _, ring := range rings {
+		sort.Slice(ring, func(i, j int) bool {
+			return math.Atan2(float64(ring[i].Y-center.Y), float64(ring[i].X-center.X)) <
+				math.Atan2(float64(ring[j].Y-center.Y), float64(ring[j].X-center.X))
+		})
+	}
+
+	return rings
+}
+
+// The default center of radial spans is the center of the bounds.
+func radialCenter(b image.Rectangle, cx, cy int) (int, int) {
+	if cx < 0 {
+		cx = b.Dx() / 2
+	}
+	if cy < 0 {
+		cy = b.Dy() / 2
+	}
+	return cx, cy
+}
+
+// Spans along rings of pixels the same rounded distance from (cx, cy). Each
+// span's id is the radius of its ring and idx is its position around the
+// ring. Negative center coordinates default to the image center.
+func generateRadialSpans(ctx context.Context, mask image.Image, cx, cy, minSpanLen int) ([]Span, error) {
+	var spans []Span = make([]Span, 0)
+	cx, cy = radialCenter(mask.Bounds(), cx, cy)
+
+	for id, ring := range radialRings(mask.Bounds(), cx, cy) {
+		if id%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var span Span = Span{id, 0, 0, false}
+		for i, p := range ring {
+			if mask.At(p.X, p.Y) == RGBAWhite {
+				if span.len == 0 {
+					span.idx = i
+				}
+				span.len++
+				continue
+			}
+			if span.len >= minSpanLen && span.len > 0 {
+				spans = append(spans, span)
+			}
+			span.len = 0
+		}
+		if span.len >= minSpanLen && span.len > 0 {
+			spans = append(spans, span)
+		}
+	}
+
+	return spans, nil
+}
+
+func generateRadialColorSpans(img image.Image, spans []Span, cx, cy int) []ColorSpan {
+	var cspans []ColorSpan = make([]ColorSpan, 0, len(spans))
+	cx, cy = radialCenter(img.Bounds(), cx, cy)
+	rings := radialRings(img.Bounds(), cx, cy)
+
+	for _, span := range spans {
+		c := make([]color.Color, span.len)
+		p := make([]image.Point, span.len)
+		for i := range span.len {
+			p[i] = rings[span.id][span.idx+i]
+			c[i] = img.At(p[i].X, p[i].Y)
+		}
+		cspans = append(cspans, ColorSpan{c, span.id, span.idx, p, nil, false})
+	}
+
+	return cspans
+}
+
+func applyRadialSpans(ctx context.Context, src image.Image, spans []ColorSpan, cx, cy int) (image.Image, error) {
+	b := src.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, out.Bounds(), src, b.Min, draw.Src)
+	cx, cy = radialCenter(b, cx, cy)
+	rings := radialRings(b, cx, cy)
+
+	for n, span := range spans {
+		if n%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for i, c := range span.pixels {
+			if span.idx+i >= len(rings[span.id]) {
+				break
+			}
+			p := rings[span.id][span.idx+i]
+			out.Set(p.X, p.Y, c)
+		}
+	}
+
+	return out, nil
+}
+
+// The pixels of an Archimedean spiral, r = spacing * θ / 2π, winding outwards
+// from the center of b until it covers the farthest corner. Consecutive
+// samples are joined with Bresenham lines. Pixels outside b are left out and
+// every pixel is only visited the first time the spiral reaches it.
+func spiralPath(b image.Rectangle, spacing float64) []image.Point {
+	var path []image.Point
+	if b.Empty() || spacing <= 0 {
+		return nil
+	}
+	cx := float64(b.Min.X) + float64(b.Dx()-1)/2
+	cy := float64(b.Min.Y) + float64(b.Dy()-1)/2
+	maxR := math.Hypot(float64(b.Dx())/2, float64(b.Dy())/2) + spacing
+	k := spacing / (2 * math.Pi)
+
+	visited := newClaimGrid(b)
+	last := image.Pt(int(math.Round(cx)), int(math.Round(cy)))
+	path = append(path, last)
+	visited[last.Y-b.Min.Y][last.X-b.Min.X] = true
+	for theta := 0.0; k*theta <= maxR; {
+		r := k * theta
+		// Steps of roughly half a pixel along the curve.
+		theta += 0.5 / math.Hypot(r, k)
+		r = k * theta
+		p := image.Pt(int(math.Round(cx+r*math.Cos(theta))), int(math.Round(cy+r*math.Sin(theta))))
+		if p == last {
+			continue
+		}
+		for _, q := range bresenhamLine(last, p)[1:] {
+			if q.In(b) && !visited[q.Y-b.Min.Y][q.X-b.Min.X] {
+				visited[q.Y-b.Min.Y][q.X-b.Min.X] = true
+				path = append(path, q)
+			}
+		}
+		last = p
+	}
+
+	return path
+}
+
+// Whether p and q are the same or neighboring pixels.
+func adjacent(p, q image.Point) bool {
+	return abs(p.X-q.X) <= 1 && abs(p.Y-q.Y) <= 1
+}
+
+// Spans along an Archimedean spiral from the center of the image, its arms
+// spacing pixels apart. Every span has id 0 and idx is its position along
+// the spiral. Spans end where the spiral leaves the image or crosses pixels
+// it already passed.
+func generateSpiralSpans(ctx context.Context, mask image.Image, spacing float64, minSpanLen int) ([]Span, error) {
+	var spans []Span = make([]Span, 0)
+	var span Span
+
+	path := spiralPath(mask.Bounds(), spacing)
+	for i, p := range path {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if span.len > 0 && !adjacent(path[i-1], p) {
+			if span.len >= minSpanLen {
+				spans = append(spans, span)
+			}
+			span.len = 0
+		}
+		if mask.At(p.X, p.Y) == RGBAWhite {
+			if span.len == 0 {
+				span.idx = i
+			}
+			span.len++
+			continue
+		}
+		if span.len >= minSpanLen && span.len > 0 {
+			spans = append(spans, span)
+		}
+		span.len = 0
+	}
+	if span.len >= minSpanLen && span.len > 0 {
+		spans = append(spans, span)
+	}
+
+	return spans, nil
+}
+
+func generateSpiralColorSpans(img image.Image, spans []Span, spacing float64) []ColorSpan {
+	var cspans []ColorSpan = make([]ColorSpan, 0, len(spans))
+	path := spiralPath(img.Bounds(), spacing)
+
+	for _, span := range spans {
+		c := make([]color.Color, span.len)
+		p := make([]image.Point, span.len)
+		for i := range span.len {
+			p[i] = path[span.idx+i]
+			c[i] = img.At(p[i].X, p[i].Y)
+		}
+		cspans = append(cspans, ColorSpan{c, span.id, span.idx, p, nil, false})
+	}
+
+	return cspans
+}
+
+func applySpiralSpans(ctx context.Context, src image.Image, spans []ColorSpan, spacing float64) (image.Image, error) {
+	b := src.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, out.Bounds(), src, b.Min, draw.Src)
+	path := spiralPath(b, spacing)
+
+	for n, span := range spans {
+		if n%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for i, c := range span.pixels {
+			if span.idx+i >= len(path) {
+				break
+			}
+			p := path[span.idx+i]
+			out.Set(p.X, p.Y, c)
+		}
+	}
+
+	return out, nil
+}
+
+// Generates curved spans that start at every unclaimed white pixel and step
+// one pixel at a time in the direction the luminance rises fastest there.
+// A path ends where the gradient vanishes, where it leaves the image or the
+// white of the mask, where it runs into a pixel already passed, or after
+// maxLen pixels.
+func generateFlowSpans(ctx context.Context, img, mask image.Image, maxLen, minSpanLen int) ([]PixelSpan, error) {
+	var spans []PixelSpan = make([]PixelSpan, 0)
+	b := mask.Bounds()
+	// Smoothing keeps paths from stalling on every speck of noise.
+	gx, gy := sobel(gaussianBlur(luminanceMap(img), 3))
+	claimed := newClaimGrid(b)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		if (y-b.Min.Y)%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var path []image.Point
+			onPath := make(map[image.Point]bool)
+			px, py := float64(x), float64(y)
+			for len(path) < maxLen {
+				p := image.Pt(int(math.Round(px)), int(math.Round(py)))
+				if !p.In(b) || onPath[p] || claimed[p.Y-b.Min.Y][p.X-b.Min.X] || mask.At(p.X, p.Y) != RGBAWhite {
+					break
+				}
+				path = append(path, p)
+				onPath[p] = true
+
+				dx, dy := gx[p.Y-b.Min.Y][p.X-b.Min.X], gy[p.Y-b.Min.Y][p.X-b.Min.X]
+				step := math.Max(math.Abs(dx), math.Abs(dy))
+				if step == 0 {
+					break
+				}
+				// Moves exactly one pixel along the major axis.
+				px, py = float64(p.X)+dx/step, float64(p.Y)+dy/step
+			}
+			spans = append(spans, generatePathSpans(mask, path, claimed, minSpanLen)...)
+		}
+	}
+
+	return spans, nil
+}